@@ -2,7 +2,10 @@
 
 var fs = require('fs')
 
-var contractsToExtract = ['IRelayHub', 'RelayHub', 'TestRecipient', 'TestPaymasterEverythingAccepted', 'GSNTypes']
+var contractsToExtract = ['IRelayHub', 'RelayHub', 'IPaymaster', 'TestRecipient', 'TestPaymasterEverythingAccepted', 'GSNTypes']
+
+// Go types bound together into the 'librelay' package (in solc --combined-json format), as they share GSNTypes structs
+var librelayTypes = { IRelayHub: 'RelayHub', IPaymaster: 'IPaymaster' }
 
 // var rhub = require("../../build/contracts/IRelayHub.json");
 // var sampleRec = require("../../build/contracts/SampleRecipient.json");
@@ -14,6 +17,19 @@ contractsToExtract.forEach(name => {
   fs.writeFileSync('../build/server/contracts/' + name + '.bin', contract.bytecode)
 })
 
+var combined = { contracts: {} }
+Object.keys(librelayTypes).forEach(type => {
+  const contract = require('../../build/contracts/' + librelayTypes[type] + '.json')
+
+  combined.contracts[librelayTypes[type] + '.sol:' + type] = {
+    abi: JSON.stringify(contract.abi),
+    bin: contract.bytecode.replace(/^0x/, ''),
+    userdoc: JSON.stringify(contract.userdoc || {}),
+    devdoc: JSON.stringify(contract.devdoc || {})
+  }
+})
+fs.writeFileSync('../build/server/contracts/librelay.json', JSON.stringify(combined))
+
 // fs.writeFileSync("../build/contracts/IRelayHub.abi", JSON.stringify(rhub.abi));
 // fs.writeFileSync("../build/contracts/IRelayHub.bin", JSON.stringify(rhub.bytecode));
 // fs.writeFileSync("../build/contracts/SampleRecipient.abi", JSON.stringify(sampleRec.abi));
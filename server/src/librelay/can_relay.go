@@ -0,0 +1,99 @@
+package librelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"openeth.dev/gen/librelay"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Reasons of canRelay() rejections, named after RelayHub's CanRelayStatus values
+const (
+	CanRelayWrongSignature             = "WrongSignature"
+	CanRelayWrongNonce                 = "WrongNonce"
+	CanRelayAcceptRelayedCallReverted  = "AcceptRelayedCallReverted"
+	CanRelayInvalidRecipientStatusCode = "InvalidRecipientStatusCode"
+	CanRelayPaymasterRejected          = "PaymasterRejected" // acceptRelayedCall() returned its own code, above 10
+	CanRelayUnknown                    = "Unknown"
+)
+
+var canRelayStatusReasons = map[uint64]string{
+	1: CanRelayWrongSignature,
+	2: CanRelayWrongNonce,
+	3: CanRelayAcceptRelayedCallReverted,
+	4: CanRelayInvalidRecipientStatusCode,
+}
+
+// Codes up to this value are reserved to RelayHub, higher ones are returned by paymasters
+const maxRelayHubCanRelayStatus = 10
+
+// CanRelayRejection describes why RelayHub's canRelay() refused a request
+type CanRelayRejection struct {
+	Status        *big.Int `json:"status"`
+	Reason        string   `json:"reason"`
+	PaymasterCode *big.Int `json:"paymasterCode,omitempty"` // the code returned by acceptRelayedCall(), for PaymasterRejected only
+	Message       string   `json:"message,omitempty"`       // the forwarder's error, or the revert reason of acceptRelayedCall()
+}
+
+// decodeCanRelayStatus names a non-zero canRelay() status. The returned context holds the forwarder's error for
+// signature and nonce failures.
+func decodeCanRelayStatus(status *big.Int, context []byte) *CanRelayRejection {
+	rejection := &CanRelayRejection{Status: status, Reason: CanRelayUnknown}
+	if !status.IsUint64() {
+		return rejection
+	}
+	if reason, ok := canRelayStatusReasons[status.Uint64()]; ok {
+		rejection.Reason = reason
+		if reason == CanRelayWrongSignature || reason == CanRelayWrongNonce {
+			rejection.Message = string(context)
+		}
+	} else if status.Uint64() > maxRelayHubCanRelayStatus {
+		rejection.Reason = CanRelayPaymasterRejected
+		rejection.PaymasterCode = status
+	}
+	return rejection
+}
+
+func (rejection *CanRelayRejection) String() string {
+	description := rejection.Reason
+	if rejection.PaymasterCode != nil {
+		description += fmt.Sprintf(" (code %s)", rejection.PaymasterCode)
+	}
+	if rejection.Message != "" {
+		description += ": " + rejection.Message
+	}
+	return description
+}
+
+// acceptRelayedCallRevertReason calls the paymaster's acceptRelayedCall() as RelayHub does, to learn why it reverted
+func (relay *RelayServer) acceptRelayedCallRevertReason(ctx context.Context, paymasterAddress common.Address, relayRequest librelay.GSNTypesRelayRequest,
+	approvalData []byte, maxPossibleGas *big.Int) string {
+	paymaster, err := librelay.NewIPaymaster(paymasterAddress, relay.Client)
+	if err != nil {
+		return err.Error()
+	}
+	_, _, err = paymaster.AcceptRelayedCall(&bind.CallOpts{From: relay.RelayHubAddress, Context: ctx}, relayRequest, approvalData, maxPossibleGas)
+	if err == nil {
+		return ""
+	}
+	return revertReason(err)
+}
+
+// revertReason extracts the reason string from the error of a reverted call, or returns the error's message
+func revertReason(err error) string {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason, unpackErr := abi.UnpackRevert(common.FromHex(data)); unpackErr == nil {
+				return reason
+			}
+		}
+	}
+	return err.Error()
+}
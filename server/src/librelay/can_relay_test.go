@@ -0,0 +1,57 @@
+package librelay
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+type revertError struct {
+	data string
+}
+
+func (err *revertError) Error() string          { return "execution reverted" }
+func (err *revertError) ErrorData() interface{} { return err.data }
+
+func TestDecodeCanRelayStatus(t *testing.T) {
+	tests := []struct {
+		status        int64
+		context       string
+		reason        string
+		paymasterCode *big.Int
+		message       string
+	}{
+		{1, "signature mismatch", CanRelayWrongSignature, nil, "signature mismatch"},
+		{2, "nonce mismatch", CanRelayWrongNonce, nil, "nonce mismatch"},
+		{3, "", CanRelayAcceptRelayedCallReverted, nil, ""},
+		{4, "", CanRelayInvalidRecipientStatusCode, nil, ""},
+		{7, "", CanRelayUnknown, nil, ""},
+		{12, "ignored", CanRelayPaymasterRejected, big.NewInt(12), ""},
+	}
+	for _, test := range tests {
+		rejection := decodeCanRelayStatus(big.NewInt(test.status), []byte(test.context))
+		if rejection.Reason != test.reason || rejection.Message != test.message ||
+			(rejection.PaymasterCode == nil) != (test.paymasterCode == nil) ||
+			(test.paymasterCode != nil && rejection.PaymasterCode.Cmp(test.paymasterCode) != 0) {
+			t.Errorf("Status %d: expected %s %v %q but got %s %v %q", test.status,
+				test.reason, test.paymasterCode, test.message, rejection.Reason, rejection.PaymasterCode, rejection.Message)
+		}
+	}
+	if description := decodeCanRelayStatus(big.NewInt(12), nil).String(); description != "PaymasterRejected (code 12)" {
+		t.Errorf("Unexpected description %s", description)
+	}
+}
+
+func TestRevertReason(t *testing.T) {
+	// Error(string) with "not approved"
+	data := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"000000000000000000000000000000000000000000000000000000000000000c" +
+		"6e6f7420617070726f7665640000000000000000000000000000000000000000"
+	if reason := revertReason(&revertError{data}); reason != "not approved" {
+		t.Errorf("Expected revert reason %q but got %q", "not approved", reason)
+	}
+	if reason := revertReason(errors.New("out of gas")); reason != "out of gas" {
+		t.Errorf("Expected the error's message but got %q", reason)
+	}
+}
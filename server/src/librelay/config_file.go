@@ -0,0 +1,73 @@
+package librelay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/naoina/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix starts the names of the environment variables holding settings, e.g. GSN_RELAY_HUB_ADDRESS
+const ConfigEnvPrefix = "GSN_"
+
+// ConfigEnvName returns the environment variable of the named setting: RelayHubAddress is read from
+// GSN_RELAY_HUB_ADDRESS
+func ConfigEnvName(name string) string {
+	var env strings.Builder
+	env.WriteString(ConfigEnvPrefix)
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (!unicode.IsUpper(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			env.WriteByte('_')
+		}
+		env.WriteRune(unicode.ToUpper(r))
+	}
+	return env.String()
+}
+
+// ReadConfigFile reads the settings of a flat YAML (.yaml, .yml), TOML (.toml) or JSON (.json) file, mapping their
+// names to their values formatted as on the command line
+func ReadConfigFile(path string) (values map[string]string, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var settings map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &settings)
+	case ".toml":
+		err = toml.Unmarshal(content, &settings)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&settings)
+	default:
+		return nil, fmt.Errorf("unknown config file format %q, expected .yaml, .yml, .toml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+
+	values = make(map[string]string, len(settings))
+	for name, value := range settings {
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case bool, int, int64, uint64, json.Number:
+			values[name] = fmt.Sprint(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return nil, fmt.Errorf("%s: %s is not a string, number or boolean", path, name)
+		}
+	}
+	return
+}
@@ -0,0 +1,71 @@
+package librelay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"openeth.dev/librelay/test"
+)
+
+func TestConfigEnvName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"RelayHubAddress":      "GSN_RELAY_HUB_ADDRESS",
+		"EthereumNodeUrl":      "GSN_ETHEREUM_NODE_URL",
+		"DevMode":              "GSN_DEV_MODE",
+		"Config":               "GSN_CONFIG",
+		"GasPriceUrlField":     "GSN_GAS_PRICE_URL_FIELD",
+		"ResendMaxCostPercent": "GSN_RESEND_MAX_COST_PERCENT",
+	} {
+		if actual := ConfigEnvName(name); actual != expected {
+			t.Errorf("Expected %s to be read from %s, got %s", name, expected, actual)
+		}
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	test.ErrFail(err, t)
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{
+		"RelayHubAddress": "0x49a984490a7762B0e5d775f0FfA608899Ebe2ee8",
+		"DefaultGasPrice": "1000000000000000000",
+		"DevMode":         "true",
+		"RequestTimeout":  "10s",
+	}
+	for name, content := range map[string]string{
+		"relay.yaml": `RelayHubAddress: "0x49a984490a7762B0e5d775f0FfA608899Ebe2ee8"
+DefaultGasPrice: 1000000000000000000
+DevMode: true
+RequestTimeout: 10s
+`,
+		"relay.toml": `RelayHubAddress = "0x49a984490a7762B0e5d775f0FfA608899Ebe2ee8"
+DefaultGasPrice = 1000000000000000000
+DevMode = true
+RequestTimeout = "10s"
+`,
+		"relay.json": `{"RelayHubAddress": "0x49a984490a7762B0e5d775f0FfA608899Ebe2ee8", "DefaultGasPrice": 1000000000000000000,
+"DevMode": true, "RequestTimeout": "10s"}`,
+	} {
+		path := filepath.Join(dir, name)
+		test.ErrFail(ioutil.WriteFile(path, []byte(content), 0600), t)
+		values, err := ReadConfigFile(path)
+		if err != nil {
+			t.Errorf("Expected %s to be read, got %v", name, err)
+		} else if !reflect.DeepEqual(values, expected) {
+			t.Errorf("Expected %s to hold %v, got %v", name, expected, values)
+		}
+	}
+
+	nested := filepath.Join(dir, "nested.yaml")
+	test.ErrFail(ioutil.WriteFile(nested, []byte("GasPriceOracle:\n  Type: fixed\n"), 0600), t)
+	if _, err = ReadConfigFile(nested); err == nil {
+		t.Errorf("Expected nested settings to be refused")
+	}
+	if _, err = ReadConfigFile(filepath.Join(dir, "relay.ini")); err == nil {
+		t.Errorf("Expected an unknown format to be refused")
+	}
+}
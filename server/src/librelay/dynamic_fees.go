@@ -0,0 +1,90 @@
+package librelay
+
+import (
+	"context"
+	"math/big"
+	"sort"
+)
+
+// Number of recent blocks, and percentile of the priority fees paid in each, sampled to suggest our priority fee
+const FeeHistoryBlocks = 20
+const FeeHistoryPercentile = 50
+
+// Minimum increase of both fee caps required by the nodes to replace a pending transaction with the same nonce
+const replacementFeeBumpPercent = 10
+
+// DynamicFees are the EIP-1559 fee parameters the relay uses for its own transactions
+type DynamicFees struct {
+	BaseFee              *big.Int // base fee of the next block
+	MaxPriorityFeePerGas *big.Int
+	MaxFeePerGas         *big.Int
+}
+
+// DynamicFees returns the fee parameters set by the last RefreshGasPrice, or nil if the chain does not support
+// EIP-1559 transactions (in which case legacy transactions are sent)
+func (relay *RelayServer) DynamicFees() *DynamicFees {
+	return relay.dynamicFees
+}
+
+// suggestDynamicFees derives the fee parameters from the latest base fee and the priority fees paid in recent blocks.
+// Returns nil if the latest block has no base fee, i.e. the London fork is not active.
+func (relay *RelayServer) suggestDynamicFees(ctx context.Context) (fees *DynamicFees, err error) {
+	latest, err := relay.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	if latest.BaseFee == nil {
+		return nil, nil
+	}
+
+	history, err := relay.Client.FeeHistory(ctx, FeeHistoryBlocks, latest.Number, []float64{FeeHistoryPercentile})
+	if err != nil {
+		return
+	}
+	baseFee := latest.BaseFee
+	// The base fee history includes the one of the block following the latest
+	if len(history.BaseFee) > 0 {
+		baseFee = history.BaseFee[len(history.BaseFee)-1]
+	}
+
+	tip := medianPriorityFee(history.Reward)
+	if tip == nil {
+		tip, err = relay.Client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return
+		}
+	}
+	tip.Mul(tip, big.NewInt(0).Add(relay.GasPricePercent, big.NewInt(100))).Div(tip, big.NewInt(100))
+
+	// Doubling the base fee keeps the tx includable for 6 consecutive full blocks
+	feeCap := new(big.Int).Mul(baseFee, big.NewInt(2))
+	feeCap.Add(feeCap, tip)
+
+	return &DynamicFees{
+		BaseFee:              baseFee,
+		MaxPriorityFeePerGas: tip,
+		MaxFeePerGas:         feeCap,
+	}, nil
+}
+
+// medianPriorityFee returns the median of the sampled priority fees, ignoring empty blocks
+func medianPriorityFee(rewards [][]*big.Int) *big.Int {
+	fees := make([]*big.Int, 0, len(rewards))
+	for _, blockRewards := range rewards {
+		if len(blockRewards) > 0 && blockRewards[0] != nil && blockRewards[0].Sign() > 0 {
+			fees = append(fees, blockRewards[0])
+		}
+	}
+	if len(fees) == 0 {
+		return nil
+	}
+	sort.Slice(fees, func(i, j int) bool { return fees[i].Cmp(fees[j]) < 0 })
+	return new(big.Int).Set(fees[len(fees)/2])
+}
+
+// minimalReplacementFee returns the lowest fee a node accepts to replace a tx paying the given one (rounding up)
+func minimalReplacementFee(previous *big.Int) *big.Int {
+	minimal := new(big.Int).Mul(previous, big.NewInt(100+replacementFeeBumpPercent))
+	minimal.Add(minimal, big.NewInt(99))
+	return minimal.Div(minimal, big.NewInt(100))
+}
@@ -0,0 +1,16 @@
+package librelay
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestMedianPriorityFee(t *testing.T) {
+	rewards := [][]*big.Int{{big.NewInt(3)}, {big.NewInt(0)}, {big.NewInt(1)}, {}, {big.NewInt(2)}}
+	if median := medianPriorityFee(rewards); median.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("Expected median priority fee 2 but got %v", median)
+	}
+	if median := medianPriorityFee([][]*big.Int{{big.NewInt(0)}}); median != nil {
+		t.Errorf("Expected no median priority fee for empty blocks but got %v", median)
+	}
+}
@@ -0,0 +1,86 @@
+package librelay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is the stable, machine readable identifier of a RelayError
+type ErrorCode string
+
+const (
+	ErrInvalidRequest         ErrorCode = "INVALID_REQUEST"
+	ErrWrongHub               ErrorCode = "WRONG_HUB"
+	ErrFeeTooLow              ErrorCode = "FEE_TOO_LOW"
+	ErrGasPriceTooLow         ErrorCode = "GAS_PRICE_TOO_LOW"
+	ErrNonceGap               ErrorCode = "NONCE_GAP"
+	ErrPaymasterRejected      ErrorCode = "PAYMASTER_REJECTED"
+	ErrPaymasterBalanceTooLow ErrorCode = "PAYMASTER_BALANCE_TOO_LOW"
+	ErrNotReady               ErrorCode = "NOT_READY"
+	ErrNotFound               ErrorCode = "NOT_FOUND"
+	ErrTimeout                ErrorCode = "TIMEOUT"
+	ErrInternal               ErrorCode = "INTERNAL"
+)
+
+var errorCodeHTTPStatus = map[ErrorCode]int{
+	ErrInvalidRequest:         http.StatusBadRequest,
+	ErrWrongHub:               http.StatusBadRequest,
+	ErrFeeTooLow:              http.StatusBadRequest,
+	ErrGasPriceTooLow:         http.StatusBadRequest,
+	ErrNonceGap:               http.StatusConflict,
+	ErrPaymasterRejected:      http.StatusUnprocessableEntity,
+	ErrPaymasterBalanceTooLow: http.StatusPaymentRequired,
+	ErrNotReady:               http.StatusServiceUnavailable,
+	ErrNotFound:               http.StatusNotFound,
+	ErrTimeout:                http.StatusGatewayTimeout,
+	ErrInternal:               http.StatusInternalServerError,
+}
+
+// RelayError is an error reported to the client that sent a request, identified by its Code
+type RelayError struct {
+	Code     ErrorCode
+	Message  string
+	CanRelay *CanRelayRejection // why RelayHub's canRelay() refused the request, for ErrPaymasterRejected only
+}
+
+// ErrorResponse is the JSON body of the HTTP responses to failed requests
+type ErrorResponse struct {
+	Error    string             `json:"error"`
+	Code     ErrorCode          `json:"code"`
+	CanRelay *CanRelayRejection `json:"canRelay,omitempty"`
+}
+
+func NewRelayError(code ErrorCode, format string, args ...interface{}) *RelayError {
+	return &RelayError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (err *RelayError) Error() string {
+	return err.Message
+}
+
+// HTTPStatus returns the status code of the HTTP response reporting the error
+func (err *RelayError) HTTPStatus() int {
+	if status, ok := errorCodeHTTPStatus[err.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+func (err *RelayError) Response() ErrorResponse {
+	return ErrorResponse{Error: err.Message, Code: err.Code, CanRelay: err.CanRelay}
+}
+
+// AsRelayError returns the RelayError wrapped by err, or an ErrTimeout one if the request's deadline was exceeded, or
+// an ErrInternal one with err's message
+func AsRelayError(err error) *RelayError {
+	var relayErr *RelayError
+	if errors.As(err, &relayErr) {
+		return relayErr
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &RelayError{Code: ErrTimeout, Message: err.Error()}
+	}
+	return &RelayError{Code: ErrInternal, Message: err.Error()}
+}
@@ -0,0 +1,47 @@
+package librelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+)
+
+func TestRelayErrorResponse(t *testing.T) {
+	relayErr := NewRelayError(ErrPaymasterRejected, "canRelay() view function returned error code=%d", 11)
+	relayErr.CanRelay = decodeCanRelayStatus(big.NewInt(11), nil)
+
+	wrapped := AsRelayError(fmt.Errorf("relaying: %w", relayErr))
+	if wrapped != relayErr || wrapped.HTTPStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("Expected wrapped paymaster rejection with status %d but got %v", http.StatusUnprocessableEntity, wrapped)
+	}
+
+	// Quotes in messages must not break the JSON body
+	internal := AsRelayError(errors.New(`node said "no"`))
+	if internal.Code != ErrInternal || internal.HTTPStatus() != http.StatusInternalServerError {
+		t.Errorf("Expected an internal error but got %v", internal)
+	}
+	body, err := json.Marshal(internal.Response())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"error":"node said \"no\"","code":"INTERNAL"}` {
+		t.Errorf("Unexpected error response %s", body)
+	}
+
+	timeout := AsRelayError(fmt.Errorf("calling canRelay(): %w", context.DeadlineExceeded))
+	if timeout.Code != ErrTimeout || timeout.HTTPStatus() != http.StatusGatewayTimeout {
+		t.Errorf("Expected a timeout error but got %v", timeout)
+	}
+
+	body, err = json.Marshal(relayErr.Response())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"error":"canRelay() view function returned error code=11","code":"PAYMASTER_REJECTED","canRelay":{"status":11,"reason":"PaymasterRejected","paymasterCode":11}}` {
+		t.Errorf("Unexpected error response %s", body)
+	}
+}
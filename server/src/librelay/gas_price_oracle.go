@@ -0,0 +1,278 @@
+package librelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// GasPriceOracle suggests the gas price the relay requires from relayed transactions, before adding GasPricePercent
+type GasPriceOracle interface {
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// Names of the gas price oracles selectable by GasPriceOracleConfig.Type
+const (
+	NodeGasPriceOracleType       = "node"
+	PercentileGasPriceOracleType = "percentile"
+	FixedGasPriceOracleType      = "fixed"
+	HTTPGasPriceOracleType       = "http"
+)
+
+// GasPriceOracleConfig selects and parameterizes the relay's gas price oracle
+type GasPriceOracleConfig struct {
+	Type string
+
+	PercentileBlocks uint64 // percentile: number of recent blocks sampled
+	Percentile       uint64 // percentile: percentile of the sampled transactions' gas prices
+
+	FixedGasPrice int64 // fixed: gas price in wei
+
+	URL        string // http: endpoint returning a JSON object
+	URLField   string // http: dot separated path of the gas price in the returned object, e.g. "data.fast"
+	URLUnit    int64  // http: wei per unit of the returned gas price, e.g. 1e9 for gwei
+	URLTimeout time.Duration
+
+	MinGasPrice      int64 // if non-zero, suggestions are raised to at least this gas price
+	MaxGasPrice      int64 // if non-zero, suggestions are lowered to at most this gas price
+	SmoothingPercent int64 // if non-zero, weight in percent of each new suggestion in an exponential moving average
+}
+
+func (config *GasPriceOracleConfig) Dump() {
+	ctx := []interface{}{"type", config.Type}
+	switch config.Type {
+	case PercentileGasPriceOracleType:
+		ctx = append(ctx, "percentile", config.Percentile, "blocks", config.PercentileBlocks)
+	case FixedGasPriceOracleType:
+		ctx = append(ctx, "gasPrice", config.FixedGasPrice)
+	case HTTPGasPriceOracleType:
+		ctx = append(ctx, "url", config.URL, "field", config.URLField, "unit", config.URLUnit)
+	}
+	ctx = append(ctx, "min", config.MinGasPrice, "max", config.MaxGasPrice, "smoothingPercent", config.SmoothingPercent)
+	log.Info("GasPriceOracle", ctx...)
+}
+
+// NewGasPriceOracle builds the oracle described by config, wrapped by the configured clamps and smoothing
+func NewGasPriceOracle(config GasPriceOracleConfig, client IClient) (oracle GasPriceOracle, err error) {
+	switch config.Type {
+	case "", NodeGasPriceOracleType:
+		oracle = &NodeGasPriceOracle{Client: client}
+	case PercentileGasPriceOracleType:
+		if config.Percentile > 100 || config.PercentileBlocks == 0 {
+			return nil, fmt.Errorf("invalid percentile gas price oracle: percentile %d of %d blocks", config.Percentile, config.PercentileBlocks)
+		}
+		oracle = &PercentileGasPriceOracle{Client: client, Blocks: config.PercentileBlocks, Percentile: config.Percentile}
+	case FixedGasPriceOracleType:
+		if config.FixedGasPrice <= 0 {
+			return nil, fmt.Errorf("invalid fixed gas price %d", config.FixedGasPrice)
+		}
+		oracle = &FixedGasPriceOracle{GasPrice: big.NewInt(config.FixedGasPrice)}
+	case HTTPGasPriceOracleType:
+		if config.URL == "" || config.URLUnit <= 0 {
+			return nil, fmt.Errorf("invalid http gas price oracle: url %q unit %d", config.URL, config.URLUnit)
+		}
+		oracle = &HTTPGasPriceOracle{
+			URL:    config.URL,
+			Field:  config.URLField,
+			Unit:   big.NewInt(config.URLUnit),
+			Client: &http.Client{Timeout: config.URLTimeout},
+		}
+	default:
+		return nil, fmt.Errorf("unknown gas price oracle %q", config.Type)
+	}
+
+	if config.SmoothingPercent < 0 || config.SmoothingPercent > 100 {
+		return nil, fmt.Errorf("invalid gas price smoothing percent %d", config.SmoothingPercent)
+	}
+	if config.SmoothingPercent != 0 && config.SmoothingPercent != 100 {
+		oracle = &SmoothedGasPriceOracle{Oracle: oracle, Percent: config.SmoothingPercent}
+	}
+
+	if config.MinGasPrice < 0 || config.MaxGasPrice < 0 || (config.MaxGasPrice != 0 && config.MinGasPrice > config.MaxGasPrice) {
+		return nil, fmt.Errorf("invalid gas price bounds: min %d max %d", config.MinGasPrice, config.MaxGasPrice)
+	}
+	if config.MinGasPrice != 0 || config.MaxGasPrice != 0 {
+		clamped := &ClampedGasPriceOracle{Oracle: oracle}
+		if config.MinGasPrice != 0 {
+			clamped.Min = big.NewInt(config.MinGasPrice)
+		}
+		if config.MaxGasPrice != 0 {
+			clamped.Max = big.NewInt(config.MaxGasPrice)
+		}
+		oracle = clamped
+	}
+	return
+}
+
+// NodeGasPriceOracle uses the node's eth_gasPrice
+type NodeGasPriceOracle struct {
+	Client IClient
+}
+
+func (oracle *NodeGasPriceOracle) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return oracle.Client.SuggestGasPrice(ctx)
+}
+
+// PercentileGasPriceOracle uses a percentile of the gas prices paid by the transactions of recent blocks
+type PercentileGasPriceOracle struct {
+	Client     IClient
+	Blocks     uint64
+	Percentile uint64
+}
+
+func (oracle *PercentileGasPriceOracle) SuggestGasPrice(ctx context.Context) (gasPrice *big.Int, err error) {
+	latest, err := oracle.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	var prices []*big.Int
+	number := new(big.Int).Set(latest.Number)
+	for i := uint64(0); i < oracle.Blocks && number.Sign() >= 0; i++ {
+		block, err := oracle.Client.BlockByNumber(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Transactions() {
+			prices = append(prices, effectiveGasPrice(tx, block.BaseFee()))
+		}
+		number.Sub(number, big.NewInt(1))
+	}
+	if len(prices) == 0 {
+		// Nothing to sample, e.g. on a fresh dev chain
+		return oracle.Client.SuggestGasPrice(ctx)
+	}
+	return gasPricePercentile(prices, oracle.Percentile), nil
+}
+
+// effectiveGasPrice returns the gas price a tx pays when included in a block with the given base fee
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil || tx.Type() != types.DynamicFeeTxType {
+		return tx.GasPrice()
+	}
+	price := new(big.Int).Add(baseFee, tx.GasTipCap())
+	if price.Cmp(tx.GasFeeCap()) > 0 {
+		return tx.GasFeeCap()
+	}
+	return price
+}
+
+func gasPricePercentile(prices []*big.Int, percentile uint64) *big.Int {
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+	index := (uint64(len(prices)-1)*percentile + 50) / 100
+	return new(big.Int).Set(prices[index])
+}
+
+// FixedGasPriceOracle always suggests the same gas price
+type FixedGasPriceOracle struct {
+	GasPrice *big.Int
+}
+
+func (oracle *FixedGasPriceOracle) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(oracle.GasPrice), nil
+}
+
+// HTTPGasPriceOracle reads the gas price from a field of a JSON object served over HTTP, such as a gas station API
+type HTTPGasPriceOracle struct {
+	URL    string
+	Field  string   // dot separated path to the gas price
+	Unit   *big.Int // wei per unit of the returned gas price
+	Client *http.Client
+}
+
+func (oracle *HTTPGasPriceOracle) SuggestGasPrice(ctx context.Context) (gasPrice *big.Int, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, oracle.URL, nil)
+	if err != nil {
+		return
+	}
+	response, err := oracle.Client.Do(request)
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gas price oracle %s returned status %s", oracle.URL, response.Status)
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return
+	}
+	if oracle.Field != "" {
+		for _, key := range strings.Split(oracle.Field, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("gas price oracle %s: no field %q in response", oracle.URL, oracle.Field)
+			}
+			value = object[key]
+		}
+	}
+
+	var number string
+	switch v := value.(type) {
+	case json.Number:
+		number = v.String()
+	case string:
+		number = v
+	default:
+		return nil, fmt.Errorf("gas price oracle %s: field %q is not a number", oracle.URL, oracle.Field)
+	}
+	price, ok := new(big.Float).SetString(number)
+	if !ok || price.Sign() <= 0 {
+		return nil, fmt.Errorf("gas price oracle %s: invalid gas price %q", oracle.URL, number)
+	}
+	gasPrice, _ = price.Mul(price, new(big.Float).SetInt(oracle.Unit)).Int(nil)
+	return
+}
+
+// ClampedGasPriceOracle bounds the suggestions of another oracle. A nil bound is not enforced.
+type ClampedGasPriceOracle struct {
+	Oracle GasPriceOracle
+	Min    *big.Int
+	Max    *big.Int
+}
+
+func (oracle *ClampedGasPriceOracle) SuggestGasPrice(ctx context.Context) (gasPrice *big.Int, err error) {
+	gasPrice, err = oracle.Oracle.SuggestGasPrice(ctx)
+	if err != nil {
+		return
+	}
+	if oracle.Min != nil && gasPrice.Cmp(oracle.Min) < 0 {
+		gasPrice = new(big.Int).Set(oracle.Min)
+	}
+	if oracle.Max != nil && gasPrice.Cmp(oracle.Max) > 0 {
+		gasPrice = new(big.Int).Set(oracle.Max)
+	}
+	return
+}
+
+// SmoothedGasPriceOracle averages the suggestions of another oracle, so a single spike does not swing the relay's
+// gas price: each suggestion weighs Percent percent against the previous average.
+type SmoothedGasPriceOracle struct {
+	Oracle  GasPriceOracle
+	Percent int64
+	average *big.Int
+}
+
+func (oracle *SmoothedGasPriceOracle) SuggestGasPrice(ctx context.Context) (gasPrice *big.Int, err error) {
+	gasPrice, err = oracle.Oracle.SuggestGasPrice(ctx)
+	if err != nil {
+		return
+	}
+	if oracle.average != nil {
+		weighted := new(big.Int).Mul(gasPrice, big.NewInt(oracle.Percent))
+		previous := new(big.Int).Mul(oracle.average, big.NewInt(100-oracle.Percent))
+		gasPrice = weighted.Add(weighted, previous).Div(weighted, big.NewInt(100))
+	}
+	oracle.average = gasPrice
+	return new(big.Int).Set(gasPrice), nil
+}
@@ -0,0 +1,103 @@
+package librelay
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"openeth.dev/librelay/test"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// blocksClient is an IClient serving the given blocks, the last one being the latest
+type blocksClient struct {
+	IClient
+	blocks   []*types.Block
+	gasPrice *big.Int
+}
+
+func (client *blocksClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return client.blocks[len(client.blocks)-1].Header(), nil
+}
+
+func (client *blocksClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return client.blocks[number.Int64()], nil
+}
+
+func (client *blocksClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return client.gasPrice, nil
+}
+
+func newGasPriceBlock(number int64, gasPrices ...int64) *types.Block {
+	var txs []*types.Transaction
+	for i, gasPrice := range gasPrices {
+		txs = append(txs, types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 21000, big.NewInt(gasPrice), nil))
+	}
+	return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(number)}).WithBody(txs, nil)
+}
+
+func assertGasPrice(t *testing.T, oracle GasPriceOracle, expected int64) {
+	t.Helper()
+	gasPrice, err := oracle.SuggestGasPrice(context.Background())
+	test.ErrFail(err, t)
+	if gasPrice.Cmp(big.NewInt(expected)) != 0 {
+		t.Errorf("Expected gas price %d but got %v", expected, gasPrice)
+	}
+}
+
+func TestPercentileGasPriceOracle(t *testing.T) {
+	client := &blocksClient{
+		blocks:   []*types.Block{newGasPriceBlock(0, 100), newGasPriceBlock(1, 5, 1), newGasPriceBlock(2, 3, 2, 4)},
+		gasPrice: big.NewInt(7),
+	}
+	oracle, err := NewGasPriceOracle(GasPriceOracleConfig{Type: PercentileGasPriceOracleType, PercentileBlocks: 2, Percentile: 60}, client)
+	test.ErrFail(err, t)
+	assertGasPrice(t, oracle, 3)
+
+	// Falls back to the node's suggestion when there is nothing to sample
+	client.blocks = []*types.Block{newGasPriceBlock(0)}
+	assertGasPrice(t, oracle, 7)
+}
+
+func TestHTTPGasPriceOracle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"fast": 12.5, "slow": "3"}}`))
+	}))
+	defer server.Close()
+
+	oracle, err := NewGasPriceOracle(GasPriceOracleConfig{Type: HTTPGasPriceOracleType, URL: server.URL, URLField: "data.fast", URLUnit: 1e9}, nil)
+	test.ErrFail(err, t)
+	assertGasPrice(t, oracle, 12.5e9)
+
+	oracle, err = NewGasPriceOracle(GasPriceOracleConfig{Type: HTTPGasPriceOracleType, URL: server.URL, URLField: "data.slow", URLUnit: 1e9}, nil)
+	test.ErrFail(err, t)
+	assertGasPrice(t, oracle, 3e9)
+
+	oracle, err = NewGasPriceOracle(GasPriceOracleConfig{Type: HTTPGasPriceOracleType, URL: server.URL, URLField: "data.missing", URLUnit: 1e9}, nil)
+	test.ErrFail(err, t)
+	if _, err = oracle.SuggestGasPrice(context.Background()); err == nil {
+		t.Error("Expected an error for a missing field")
+	}
+}
+
+func TestClampedSmoothedGasPriceOracle(t *testing.T) {
+	fixed := &FixedGasPriceOracle{GasPrice: big.NewInt(100)}
+	smoothed := &SmoothedGasPriceOracle{Oracle: fixed, Percent: 50}
+	oracle := &ClampedGasPriceOracle{Oracle: smoothed, Min: big.NewInt(150), Max: big.NewInt(250)}
+
+	assertGasPrice(t, oracle, 150)
+	fixed.GasPrice = big.NewInt(500)
+	assertGasPrice(t, oracle, 250) // average is 300
+	fixed.GasPrice = big.NewInt(100)
+	assertGasPrice(t, oracle, 200)
+
+	if _, err := NewGasPriceOracle(GasPriceOracleConfig{Type: FixedGasPriceOracleType, FixedGasPrice: 1, MinGasPrice: 2, MaxGasPrice: 1}, nil); err == nil {
+		t.Error("Expected an error for a minimum above the maximum")
+	}
+	if _, err := NewGasPriceOracle(GasPriceOracleConfig{Type: "unknown"}, nil); err == nil {
+		t.Error("Expected an error for an unknown oracle")
+	}
+}
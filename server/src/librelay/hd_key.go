@@ -0,0 +1,40 @@
+package librelay
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// DeriveKey returns the key at path of the BIP-39 mnemonic's BIP-32 tree, the one wallets derive from the same
+// mnemonic and passphrase
+func DeriveKey(mnemonic string, passphrase string, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, fmt.Errorf("invalid mnemonic")
+	}
+	return deriveKeyFromSeed(bip39.NewSeed(mnemonic, passphrase), path)
+}
+
+// deriveKeyFromSeed derives the BIP-32 private key at path from the master key of seed, with btcutil's hdkeychain.
+// The network parameters only select the serialization of extended keys, which is never used.
+func deriveKeyFromSeed(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	key, err := hdkeychain.NewMaster(seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %v", err)
+	}
+	for _, index := range path {
+		// Derive, unlike the deprecated DeriveNonStandard, keeps the leading zeros of private keys as BIP-32 requires
+		if key, err = key.Derive(index); err != nil {
+			return nil, fmt.Errorf("cannot derive %s: %v", path, err)
+		}
+	}
+	privateKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return privateKey.ToECDSA(), nil
+}
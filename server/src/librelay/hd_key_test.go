@@ -0,0 +1,81 @@
+package librelay
+
+import (
+	"encoding/hex"
+	"openeth.dev/librelay/test"
+	"testing"
+
+	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDeriveKeyFromSeed(t *testing.T) {
+	const hardened = 0x80000000
+	seeds := []string{
+		"000102030405060708090a0b0c0d0e0f",
+		"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542",
+		// Its m/0H key has a leading zero byte, which early implementations dropped
+		"4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be",
+	}
+	// BIP-32 test vectors 1 to 3, then the edges of the non-hardened indexes
+	for _, vector := range []struct {
+		seed     int
+		path     accounts.DerivationPath
+		expected string
+	}{
+		{0, accounts.DerivationPath{}, "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"},
+		{0, accounts.DerivationPath{hardened}, "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7"},
+		{0, accounts.DerivationPath{hardened, 1}, "xprv9wTYmMFdV23N2TdNG573QoEsfRrWKQgWeibmLntzniatZvR9BmLnvSxqu53Kw1UmYPxLgboyZQaXwTCg8MSY3H2EU4pWcQDnRnrVA1xe8fs"},
+		{0, accounts.DerivationPath{hardened, 1, hardened + 2}, "xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuEzG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM"},
+		{0, accounts.DerivationPath{hardened, 1, hardened + 2, 2}, "xprvA2JDeKCSNNZky6uBCviVfJSKyQ1mDYahRjijr5idH2WwLsEd4Hsb2Tyh8RfQMuPh7f7RtyzTtdrbdqqsunu5Mm3wDvUAKRHSC34sJ7in334"},
+		{0, accounts.DerivationPath{hardened, 1, hardened + 2, 2, 1000000000}, "xprvA41z7zogVVwxVSgdKUHDy1SKmdb533PjDz7J6N6mV6uS3ze1ai8FHa8kmHScGpWmj4WggLyQjgPie1rFSruoUihUZREPSL39UNdE3BBDu76"},
+		{1, accounts.DerivationPath{}, "xprv9s21ZrQH143K31xYSDQpPDxsXRTUcvj2iNHm5NUtrGiGG5e2DtALGdso3pGz6ssrdK4PFmM8NSpSBHNqPqm55Qn3LqFtT2emdEXVYsCzC2U"},
+		{1, accounts.DerivationPath{0}, "xprv9vHkqa6EV4sPZHYqZznhT2NPtPCjKuDKGY38FBWLvgaDx45zo9WQRUT3dKYnjwih2yJD9mkrocEZXo1ex8G81dwSM1fwqWpWkeS3v86pgKt"},
+		{1, accounts.DerivationPath{0, hardened + 2147483647}, "xprv9wSp6B7kry3Vj9m1zSnLvN3xH8RdsPP1Mh7fAaR7aRLcQMKTR2vidYEeEg2mUCTAwCd6vnxVrcjfy2kRgVsFawNzmjuHc2YmYRmagcEPdU9"},
+		{1, accounts.DerivationPath{0, hardened + 2147483647, 1}, "xprv9zFnWC6h2cLgpmSA46vutJzBcfJ8yaJGg8cX1e5StJh45BBciYTRXSd25UEPVuesF9yog62tGAQtHjXajPPdbRCHuWS6T8XA2ECKADdw4Ef"},
+		{1, accounts.DerivationPath{0, hardened + 2147483647, 1, hardened + 2147483646}, "xprvA1RpRA33e1JQ7ifknakTFpgNXPmW2YvmhqLQYMmrj4xJXXWYpDPS3xz7iAxn8L39njGVyuoseXzU6rcxFLJ8HFsTjSyQbLYnMpCqE2VbFWc"},
+		{1, accounts.DerivationPath{0, hardened + 2147483647, 1, hardened + 2147483646, 2}, "xprvA2nrNbFZABcdryreWet9Ea4LvTJcGsqrMzxHx98MMrotbir7yrKCEXw7nadnHM8Dq38EGfSh6dqA9QWTyefMLEcBYJUuekgW4BYPJcr9E7j"},
+		{2, accounts.DerivationPath{}, "xprv9s21ZrQH143K25QhxbucbDDuQ4naNntJRi4KUfWT7xo4EKsHt2QJDu7KXp1A3u7Bi1j8ph3EGsZ9Xvz9dGuVrtHHs7pXeTzjuxBrCmmhgC6"},
+		{2, accounts.DerivationPath{hardened}, "xprv9uPDJpEQgRQfDcW7BkF7eTya6RPxXeJCqCJGHuCJ4GiRVLzkTXBAJMu2qaMWPrS7AANYqdq6vcBcBUdJCVVFceUvJFjaPdGZ2y9WACViL4L"},
+		{0, accounts.DerivationPath{0, 1, 2, 2, 1000000000}, "xprvA3XhazxncJqJsQcG85Gg61qwPQKiobAnWjuPpjKhExprZjfse6nErRwTMwGe6uGWXPSykZSTiYb2TXAm7Qhwj8KgRd2XaD21Styu6h6AwFz"},
+		{1, accounts.DerivationPath{0, 2147483647}, "xprv9wSp6B7cXJWXZRpDbxkFg3ry2fuSyUfvboJ5Yi6YNw7i1bXmq9QwQ7EwMpeG4cK2pnMqEx1cLYD7cSGSCtruGSXC6ZSVDHugMsZgbuY62m6"},
+	} {
+		seed, err := hex.DecodeString(seeds[vector.seed])
+		test.ErrFail(err, t)
+		expected, err := hdkeychain.NewKeyFromString(vector.expected)
+		test.ErrFail(err, t)
+		expectedKey, err := expected.ECPrivKey()
+		test.ErrFail(err, t)
+
+		key, err := deriveKeyFromSeed(seed, vector.path)
+		test.ErrFail(err, t)
+		if actual, want := hex.EncodeToString(crypto.FromECDSA(key)), hex.EncodeToString(crypto.FromECDSA(expectedKey.ToECDSA())); actual != want {
+			t.Errorf("Expected key %s at %s of seed %d, got %s", want, vector.path, vector.seed+1, actual)
+		}
+	}
+
+	if _, err := deriveKeyFromSeed([]byte{1, 2, 3}, accounts.DefaultBaseDerivationPath); err == nil {
+		t.Errorf("Expected a seed shorter than 128 bits to be refused")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	mnemonic := "test test test test test test test test test test test junk"
+	for path, expected := range map[string]common.Address{
+		"m/44'/60'/0'/0/0": common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"),
+		"m/44'/60'/0'/0/1": common.HexToAddress("0x70997970C51812dc3A010C7d01b50e0d17dc79C8"),
+	} {
+		derivationPath, err := accounts.ParseDerivationPath(path)
+		test.ErrFail(err, t)
+		key, err := DeriveKey(mnemonic, "", derivationPath)
+		test.ErrFail(err, t)
+		if actual := crypto.PubkeyToAddress(key.PublicKey); actual != expected {
+			t.Errorf("Expected address %s at %s, got %s", expected.Hex(), path, actual.Hex())
+		}
+	}
+	if _, err := DeriveKey("test test test", "", accounts.DefaultBaseDerivationPath); err == nil {
+		t.Errorf("Expected an invalid mnemonic to be refused")
+	}
+}
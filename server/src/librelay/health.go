@@ -0,0 +1,35 @@
+package librelay
+
+import (
+	"context"
+	"time"
+)
+
+// HealthCheckTimeout bounds the time Health() waits for the ethereum node
+const HealthCheckTimeout = 5 * time.Second
+
+// Health tells whether the relay's process can work at all, regardless of its registration on RelayHub
+type Health struct {
+	Healthy      bool   `json:"healthy"`
+	EthereumNode string `json:"ethereumNode"` // "ok", or the error reaching the node
+	Database     string `json:"database"`     // "ok", or the error reading the transactions store
+}
+
+const healthOK = "ok"
+
+// Health checks that the ethereum node answers and the transactions store is open
+func (relay *RelayServer) Health(ctx context.Context) *Health {
+	health := &Health{EthereumNode: healthOK, Database: healthOK}
+
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	if _, err := relay.Client.HeaderByNumber(ctx, nil); err != nil {
+		health.EthereumNode = err.Error()
+	}
+	if _, err := relay.TxStore.GetFirstTransaction(); err != nil {
+		health.Database = err.Error()
+	}
+
+	health.Healthy = health.EthereumNode == healthOK && health.Database == healthOK
+	return health
+}
@@ -0,0 +1,40 @@
+package librelay
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Logger is a leveled logger of messages with key/value context, such as a relay request's ID
+type Logger = log.Logger
+
+// Formats of the log lines
+const (
+	LogFormatLogfmt   = "logfmt"
+	LogFormatJSON     = "json"
+	LogFormatTerminal = "terminal"
+)
+
+// SetupLogging sends the records of the root logger, which every logger descends from, to w in the given format, and
+// drops those below the given level (crit, error, warn, info, debug or trace)
+func SetupLogging(w io.Writer, format string, level string) (err error) {
+	var logFormat log.Format
+	switch format {
+	case LogFormatLogfmt:
+		logFormat = log.LogfmtFormat()
+	case LogFormatJSON:
+		logFormat = log.JSONFormat()
+	case LogFormatTerminal:
+		logFormat = log.TerminalFormat(false)
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+	lvl, err := log.LvlFromString(level)
+	if err != nil {
+		return
+	}
+	log.Root().SetHandler(log.LvlFilterHandler(lvl, log.CallerFileHandler(log.StreamHandler(w, logFormat))))
+	return
+}
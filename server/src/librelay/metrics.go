@@ -0,0 +1,86 @@
+package librelay
+
+import (
+	"math/big"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "gsn_relay"
+
+// The metrics of a relay's own state carry a relay label, its address, as a process may run several relays (e.g. in
+// tests) which would otherwise overwrite each other's values
+var (
+	balanceGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "balance_wei",
+		Help:      "Relay's balance, as of the last check",
+	}, []string{"relay"})
+	stakeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stake_wei",
+		Help:      "Relay's stake on RelayHub, as of the last check",
+	}, []string{"relay"})
+	gasPriceGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "gas_price_wei",
+		Help:      "Minimal gas price of relay requests, see RelayServer.GasPrice()",
+	}, []string{"relay"})
+	pendingTransactionsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_transactions",
+		Help:      "Transactions sent by the relay and not confirmed yet",
+	}, []string{"relay"})
+	resentTransactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "resent_transactions_total",
+		Help:      "Stuck transactions resent with bumped fees",
+	}, []string{"relay"})
+	blocksSinceRegistrationGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "blocks_since_registration",
+		Help:      "Blocks mined since the relay's last RelayAdded event",
+	}, []string{"relay"})
+	chainCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "chain_call_duration_seconds",
+		Help:      "Latency of the chain calls made to relay a request, by call",
+	}, []string{"call"})
+	canRelayRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "canrelay_rejections_total",
+		Help:      "Relay requests refused by RelayHub's canRelay(), by reason",
+	}, []string{"reason"})
+	penalizations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "penalizations_total",
+		Help:      "Penalizations of offending relays sent by the PenalizationWatcher, by offense",
+	}, []string{"offense"})
+)
+
+// chainCallTimer starts timing a chain call, to be stopped with ObserveDuration()
+func chainCallTimer(call string) *prometheus.Timer {
+	return prometheus.NewTimer(chainCallDuration.WithLabelValues(call))
+}
+
+// relayLabel returns the value of the relay label of the relay's metrics
+func (relay *RelayServer) relayLabel() string {
+	return relay.Address().Hex()
+}
+
+// setWeiGauge sets the relay's gauge of gauges to wei
+func (relay *RelayServer) setWeiGauge(gauges *prometheus.GaugeVec, wei *big.Int) {
+	value, _ := new(big.Float).SetInt(wei).Float64()
+	gauges.WithLabelValues(relay.relayLabel()).Set(value)
+}
+
+// updatePendingTransactionsGauge counts the transactions of the store
+func (relay *RelayServer) updatePendingTransactionsGauge() {
+	txs, err := relay.TxStore.ListTransactions()
+	if err != nil {
+		relay.Logger.Error("Error counting pending transactions", "err", err)
+		return
+	}
+	pendingTransactionsGauge.WithLabelValues(relay.relayLabel()).Set(float64(len(txs)))
+}
@@ -0,0 +1,178 @@
+package librelay
+
+import (
+	"context"
+	"fmt"
+	"openeth.dev/librelay/txstore"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// NonceManager hands out the nonces of the transactions sent from a relay's account.
+// It reconciles the node's pending nonce, the transactions kept in the TxStore and the nonces it issued itself
+// (which the node may not have seen yet). A nonce is reserved while a transaction is signed and sent, and must then be
+// either committed if the transaction was broadcast, or released if it was not, so a failed send does not burn it.
+type NonceManager struct {
+	address common.Address
+	client  IClient
+	txStore txstore.ITxStore
+	logger  Logger
+
+	reservation chan struct{} // holds a token from Reserve until Commit or Release
+	mutex       *sync.Mutex   // guards the fields below
+	nextNonce   uint64
+	checkedGaps bool
+}
+
+// NewNonceManager returns a NonceManager of the given account, logging through the logger of the relay it serves
+func NewNonceManager(address common.Address, client IClient, txStore txstore.ITxStore, logger Logger) *NonceManager {
+	return &NonceManager{
+		address:     address,
+		client:      client,
+		txStore:     txStore,
+		logger:      logger,
+		reservation: make(chan struct{}, 1),
+		mutex:       &sync.Mutex{},
+	}
+}
+
+// Reserve returns the nonce for the next transaction, and blocks any other reservation until it is committed or
+// released. On dev mode, the node's pending nonce is always trusted, so nonces get reused after the chain is reverted.
+// Fails without reserving anything if ctx is done before the nonce is reserved.
+func (manager *NonceManager) Reserve(ctx context.Context, devMode bool) (nonce uint64, err error) {
+	if err = manager.lock(ctx); err != nil {
+		return
+	}
+	nonce, err = manager.reconcile(ctx, devMode)
+	if err != nil {
+		manager.unlock()
+	}
+	return
+}
+
+// ReserveNonce blocks any other reservation as Reserve does, for a given nonce below the next one, such as one of the
+// Gaps. It must then be committed or released.
+func (manager *NonceManager) ReserveNonce(ctx context.Context, nonce uint64) (err error) {
+	if err = manager.lock(ctx); err != nil {
+		return
+	}
+	nextNonce, err := manager.reconcile(ctx, false)
+	if err == nil && nonce >= nextNonce {
+		err = fmt.Errorf("cannot reserve nonce %d, at or above the next nonce %d", nonce, nextNonce)
+	}
+	if err != nil {
+		manager.unlock()
+	}
+	return
+}
+
+// lock waits for the reservation until ctx is done, and then gives it back rather than letting a transaction be signed
+// past the deadline
+func (manager *NonceManager) lock(ctx context.Context) error {
+	select {
+	case manager.reservation <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		manager.unlock()
+		return err
+	}
+	return nil
+}
+
+func (manager *NonceManager) unlock() {
+	<-manager.reservation
+}
+
+// Commit marks a reserved nonce as used by a broadcast transaction
+func (manager *NonceManager) Commit(nonce uint64) {
+	manager.mutex.Lock()
+	if nonce+1 > manager.nextNonce {
+		manager.nextNonce = nonce + 1
+	}
+	manager.mutex.Unlock()
+	manager.unlock()
+}
+
+// Release gives back a reserved nonce that was not used, so it is handed out again by the next reservation
+func (manager *NonceManager) Release(nonce uint64) {
+	manager.logger.Debug("NonceManager: releasing unused nonce", "nonce", nonce)
+	manager.unlock()
+}
+
+// NextNonce returns the nonce the next transaction is expected to use, as far as this relay knows
+func (manager *NonceManager) NextNonce() uint64 {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.nextNonce
+}
+
+// Gaps returns the nonces, at or above the node's pending nonce, of which no transaction is stored although a
+// transaction with a higher nonce is. These are usually left behind by a crash between sending and storing a tx, and
+// stall all later transactions until they are filled.
+func (manager *NonceManager) Gaps(ctx context.Context) (gaps []uint64, err error) {
+	pending, err := manager.client.PendingNonceAt(ctx, manager.address)
+	if err != nil {
+		return
+	}
+	txs, err := manager.txStore.ListTransactions()
+	if err != nil {
+		return
+	}
+	return nonceGaps(pending, txs), nil
+}
+
+func (manager *NonceManager) reconcile(ctx context.Context, devMode bool) (nonce uint64, err error) {
+	nonce, err = manager.client.PendingNonceAt(ctx, manager.address)
+	if err != nil {
+		manager.logger.Error("NonceManager: error polling pending nonce", "err", err)
+		return
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	if devMode {
+		manager.nextNonce = nonce
+		return
+	}
+
+	txs, err := manager.txStore.ListTransactions()
+	if err != nil {
+		manager.logger.Error("NonceManager: error listing stored transactions", "err", err)
+		return
+	}
+
+	if !manager.checkedGaps {
+		if gaps := nonceGaps(nonce, txs); len(gaps) > 0 {
+			manager.logger.Warn("NonceManager: missing transactions for nonces", "gaps", gaps, "pendingNonce", nonce)
+		}
+		manager.checkedGaps = true
+	}
+
+	// Transactions we stored or issued may not have reached the node's pending pool yet
+	if len(txs) > 0 && txs[len(txs)-1].Nonce()+1 > nonce {
+		nonce = txs[len(txs)-1].Nonce() + 1
+	}
+	if manager.nextNonce > nonce {
+		nonce = manager.nextNonce
+	}
+	manager.nextNonce = nonce
+	return
+}
+
+// nonceGaps returns the nonces from pending up to the highest stored one that have no stored transaction
+func nonceGaps(pending uint64, txs []*txstore.TimestampedTransaction) (gaps []uint64) {
+	expected := pending
+	for _, tx := range txs {
+		for ; expected < tx.Nonce(); expected++ {
+			gaps = append(gaps, expected)
+		}
+		if tx.Nonce()+1 > expected {
+			expected = tx.Nonce() + 1
+		}
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package librelay
+
+import (
+	"context"
+	"math/big"
+	"openeth.dev/librelay/test"
+	"openeth.dev/librelay/txstore"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// nonceClient is an IClient whose pending nonce is set by the test
+type nonceClient struct {
+	IClient
+	pendingNonce uint64
+}
+
+func (client *nonceClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return client.pendingNonce, nil
+}
+
+func newNonceTx(nonce uint64) *types.Transaction {
+	return types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+}
+
+func TestNonceManagerReserveCommitRelease(t *testing.T) {
+	client := &nonceClient{pendingNonce: 3}
+	manager := NewNonceManager(common.Address{}, client, txstore.NewMemoryTxStore(nil), log.Root())
+
+	nonce, err := manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	if nonce != 3 {
+		t.Errorf("Expected nonce 3 but got %v", nonce)
+	}
+	manager.Release(nonce)
+
+	// A released nonce is handed out again
+	nonce, err = manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	if nonce != 3 {
+		t.Errorf("Expected released nonce 3 to be reused but got %v", nonce)
+	}
+	manager.Commit(nonce)
+
+	// A committed nonce is not, even before the node sees the tx
+	nonce, err = manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	if nonce != 4 {
+		t.Errorf("Expected nonce 4 after commit but got %v", nonce)
+	}
+	manager.Commit(nonce)
+	if manager.NextNonce() != 5 {
+		t.Errorf("Expected next nonce 5 but got %v", manager.NextNonce())
+	}
+
+	// Dev mode trusts the node
+	nonce, err = manager.Reserve(context.Background(), true)
+	test.ErrFail(err, t)
+	if nonce != 3 {
+		t.Errorf("Expected node's pending nonce 3 on dev mode but got %v", nonce)
+	}
+	manager.Release(nonce)
+}
+
+func TestNonceManagerReserveHonorsContext(t *testing.T) {
+	client := &nonceClient{pendingNonce: 3}
+	manager := NewNonceManager(common.Address{}, client, txstore.NewMemoryTxStore(nil), log.Root())
+
+	nonce, err := manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+
+	// Another reservation gives up at its deadline instead of waiting for the first one
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = manager.Reserve(ctx, false); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded while nonce %d is reserved but got %v", nonce, err)
+	}
+	manager.Release(nonce)
+
+	// Nor does it reserve a free nonce once its deadline has passed
+	if _, err = manager.Reserve(ctx, false); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded after the deadline but got %v", err)
+	}
+	nonce, err = manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	manager.Release(nonce)
+}
+
+func TestNonceManagerReserveNonce(t *testing.T) {
+	client := &nonceClient{pendingNonce: 3}
+	store := txstore.NewMemoryTxStore(nil)
+	test.ErrFail(store.SaveTransaction(newNonceTx(5)), t)
+	manager := NewNonceManager(common.Address{}, client, store, log.Root())
+
+	test.ErrFail(manager.ReserveNonce(context.Background(), 4), t)
+	manager.Commit(4)
+	if manager.NextNonce() != 6 {
+		t.Errorf("Expected next nonce 6 after filling nonce 4 but got %v", manager.NextNonce())
+	}
+	if manager.ReserveNonce(context.Background(), 6) == nil {
+		t.Errorf("Expected the next nonce 6 not to be reserved as a given one")
+	}
+	nonce, err := manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	if nonce != 6 {
+		t.Errorf("Expected nonce 6 but got %v", nonce)
+	}
+	manager.Release(nonce)
+}
+
+func TestNonceManagerReconcilesTxStore(t *testing.T) {
+	client := &nonceClient{pendingNonce: 2}
+	store := txstore.NewMemoryTxStore(nil)
+	test.ErrFail(store.SaveTransaction(newNonceTx(2)), t)
+	test.ErrFail(store.SaveTransaction(newNonceTx(3)), t)
+	test.ErrFail(store.SaveTransaction(newNonceTx(5)), t)
+	manager := NewNonceManager(common.Address{}, client, store, log.Root())
+
+	// A restarted relay must not reuse the nonces of stored transactions the node has not seen
+	nonce, err := manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	if nonce != 6 {
+		t.Errorf("Expected nonce 6 after stored txs but got %v", nonce)
+	}
+	manager.Release(nonce)
+
+	gaps, err := manager.Gaps(context.Background())
+	test.ErrFail(err, t)
+	if !reflect.DeepEqual(gaps, []uint64{4}) {
+		t.Errorf("Expected gap at nonce 4 but got %v", gaps)
+	}
+
+	client.pendingNonce = 4
+	gaps, err = manager.Gaps(context.Background())
+	test.ErrFail(err, t)
+	if !reflect.DeepEqual(gaps, []uint64{4}) {
+		t.Errorf("Expected gap at nonce 4 but got %v", gaps)
+	}
+
+	client.pendingNonce = 6
+	gaps, err = manager.Gaps(context.Background())
+	test.ErrFail(err, t)
+	if len(gaps) != 0 {
+		t.Errorf("Expected no gaps but got %v", gaps)
+	}
+}
+
+func TestNonceManagerLogsThroughRelayLogger(t *testing.T) {
+	var records []*log.Record
+	logger := log.New("relay", "test")
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	client := &nonceClient{pendingNonce: 2}
+	store := txstore.NewMemoryTxStore(nil)
+	test.ErrFail(store.SaveTransaction(newNonceTx(3)), t)
+	manager := NewNonceManager(common.Address{}, client, store, logger)
+
+	nonce, err := manager.Reserve(context.Background(), false)
+	test.ErrFail(err, t)
+	manager.Release(nonce)
+
+	if len(records) != 2 || records[0].Lvl != log.LvlWarn || records[1].Lvl != log.LvlDebug {
+		t.Fatalf("Expected the gap warning and the release to be logged, but got %v", records)
+	}
+	for _, record := range records {
+		if len(record.Ctx) < 2 || record.Ctx[0] != "relay" || record.Ctx[1] != "test" {
+			t.Errorf("Expected %q to be logged with the relay's context, but got %v", record.Msg, record.Ctx)
+		}
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"context"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
-	"log"
 	"math/big"
 )
 
@@ -33,7 +33,7 @@ func (openethClient *OpenethClient) SuggestGasPrice(ctx context.Context) (*big.I
 	gasPrice,err := openethClient.Client.SuggestGasPrice(ctx)
 	if err == nil && gasPrice.Uint64() == 0 {
 		gasPrice = big.NewInt(openethClient.DefaultGasPrice)//big.NewInt(params.GWei)
-		log.Println("New gasPrice is", gasPrice.Uint64())
+		log.Debug("Node suggested no gas price, using the default one", "gasPrice", gasPrice)
 	}
 	return gasPrice,err
 }
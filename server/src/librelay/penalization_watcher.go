@@ -0,0 +1,360 @@
+package librelay
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"openeth.dev/gen/librelay"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Offenses penalized by RelayHub
+const (
+	OffenseRepeatedNonce      = "RepeatedNonce"      // two different transactions signed with the same nonce
+	OffenseIllegalTransaction = "IllegalTransaction" // a transaction other than registerRelay() or relayCall()
+)
+
+// States of RelayHub's getRelay() in which a relay can be penalized
+const (
+	relayStateStaked     = 1
+	relayStateRegistered = 2
+	relayStateRemoved    = 3
+)
+
+const (
+	// Most blocks scanned at once, so a watcher falling behind catches up over several scans
+	maxBlocksPerScan = 100
+	// Observed transactions are kept for this many blocks, to be compared with the later ones
+	observationRetentionBlocks = 5760
+)
+
+// Offense is a relay's transaction, or pair of transactions, for which RelayHub penalizes it
+type Offense struct {
+	Kind               string         `json:"kind"`
+	Relay              common.Address `json:"relay"`
+	Nonce              uint64         `json:"nonce"`
+	Transactions       []common.Hash  `json:"transactions"`
+	PenalizationTxHash *common.Hash   `json:"penalizationTxHash,omitempty"`
+
+	txs []*types.Transaction
+}
+
+type observation struct {
+	tx    *types.Transaction
+	block uint64
+}
+
+// PenalizationWatcher scans new blocks, and the node's pending block if ScanPending, for the transactions of relays
+// staked on RelayHub, which may also be observed from other sources, such as the transactions relays returned to
+// clients. It penalizes the relays that signed two different transactions with the same nonce, or any transaction
+// other than calls to RelayHub's registerRelay() and relayCall(), to collect half of their stake.
+// Penalizations are sent with a reporter key of their own: a relay sending them would commit an illegal transaction.
+// Only legacy transactions are checked, as RelayHub cannot decode typed ones.
+type PenalizationWatcher struct {
+	Client          IClient
+	RelayHubAddress common.Address
+	ScanPending     bool
+	Logger          Logger
+
+	rhub        *librelay.IRelayHub
+	rhubABI     abi.ABI
+	reporterKey *ecdsa.PrivateKey
+	ignored     common.Address // the watcher's own relay
+	chainID     *big.Int
+
+	mutex     *sync.Mutex // guards the fields below
+	scanned   bool        // whether Scan ran, the first scan starting at the latest block
+	nextBlock uint64
+	relays    map[common.Address]bool                   // relays staked up to nextBlock
+	observed  map[common.Address]map[uint64]observation // by relay and nonce
+	penalized map[common.Address]bool                   // relays penalized, or found not to be penalizable
+}
+
+func NewPenalizationWatcher(client IClient, relayHubAddress common.Address, reporterKey *ecdsa.PrivateKey, ownRelay common.Address) (*PenalizationWatcher, error) {
+	rhub, err := librelay.NewIRelayHub(relayHubAddress, client)
+	if err != nil {
+		return nil, err
+	}
+	rhubABI, err := abi.JSON(strings.NewReader(librelay.IRelayHubABI))
+	if err != nil {
+		return nil, err
+	}
+	return &PenalizationWatcher{
+		Client:          client,
+		RelayHubAddress: relayHubAddress,
+		Logger:          log.Root(),
+		rhub:            rhub,
+		rhubABI:         rhubABI,
+		reporterKey:     reporterKey,
+		ignored:         ownRelay,
+		mutex:           &sync.Mutex{},
+		relays:          map[common.Address]bool{},
+		observed:        map[common.Address]map[uint64]observation{},
+		penalized:       map[common.Address]bool{},
+	}, nil
+}
+
+// ReporterAddress is the account sending the penalizations and collecting the rewards, which must be funded for gas
+func (watcher *PenalizationWatcher) ReporterAddress() common.Address {
+	return crypto.PubkeyToAddress(watcher.reporterKey.PublicKey)
+}
+
+// Scan observes the transactions of the blocks mined since the previous scan, and of the pending block if ScanPending.
+// Returns the offenses found, with the hash of their penalization if it could be sent.
+func (watcher *PenalizationWatcher) Scan(ctx context.Context) (offenses []*Offense, err error) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	if err = watcher.updateChainID(ctx); err != nil {
+		return
+	}
+	latest, err := watcher.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	latestBlock := latest.Number.Uint64()
+	relaysFrom := watcher.nextBlock
+	if !watcher.scanned {
+		relaysFrom, watcher.nextBlock = 0, latestBlock
+	}
+	lastBlock := latestBlock
+	if lastBlock >= watcher.nextBlock+maxBlocksPerScan {
+		lastBlock = watcher.nextBlock + maxBlocksPerScan - 1
+	}
+
+	err = watcher.updateRelays(ctx, relaysFrom, &lastBlock)
+	if err != nil {
+		return
+	}
+	watcher.scanned = true
+
+	for number := watcher.nextBlock; number <= lastBlock; number++ {
+		block, err := watcher.Client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return offenses, err
+		}
+		offenses = append(offenses, watcher.observeAll(ctx, block.Transactions(), number)...)
+		watcher.nextBlock = number + 1
+	}
+	watcher.prune(lastBlock)
+
+	if watcher.ScanPending {
+		pending, err := watcher.Client.BlockByNumber(ctx, big.NewInt(int64(rpc.PendingBlockNumber)))
+		if err != nil {
+			// Not all nodes serve the pending block
+			watcher.Logger.Debug("PenalizationWatcher: error getting pending block", "err", err)
+			return offenses, nil
+		}
+		offenses = append(offenses, watcher.observeAll(ctx, pending.Transactions(), lastBlock)...)
+	}
+	return
+}
+
+// Observe checks a signed transaction obtained outside of the scanned blocks, such as one returned to a client,
+// against those observed before, and penalizes its sender if it is an offense
+func (watcher *PenalizationWatcher) Observe(ctx context.Context, tx *types.Transaction) (offense *Offense, err error) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	if err = watcher.updateChainID(ctx); err != nil {
+		return
+	}
+	if !watcher.scanned {
+		if err = watcher.updateRelays(ctx, 0, nil); err != nil {
+			return
+		}
+	}
+	offense, err = watcher.observe(tx, watcher.nextBlock)
+	if offense == nil || err != nil {
+		return
+	}
+	err = watcher.penalize(ctx, offense)
+	return
+}
+
+func (watcher *PenalizationWatcher) observeAll(ctx context.Context, txs types.Transactions, block uint64) (offenses []*Offense) {
+	for _, tx := range txs {
+		offense, err := watcher.observe(tx, block)
+		if err != nil {
+			watcher.Logger.Debug("PenalizationWatcher: error observing transaction", "txHash", tx.Hash(), "err", err)
+			continue
+		}
+		if offense == nil {
+			continue
+		}
+		if err = watcher.penalize(ctx, offense); err != nil {
+			watcher.Logger.Error("PenalizationWatcher: error penalizing relay", "relay", offense.Relay, "offense", offense.Kind, "err", err)
+		}
+		offenses = append(offenses, offense)
+	}
+	return
+}
+
+// observe records tx if it was sent by a relay, and returns the offense it commits, if any
+func (watcher *PenalizationWatcher) observe(tx *types.Transaction, block uint64) (offense *Offense, err error) {
+	if tx.Type() != types.LegacyTxType {
+		return
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(watcher.chainID), tx)
+	if err != nil {
+		return
+	}
+	if !watcher.relays[sender] || sender == watcher.ignored || watcher.penalized[sender] {
+		return
+	}
+
+	if !watcher.isLegal(tx) {
+		return &Offense{Kind: OffenseIllegalTransaction, Relay: sender, Nonce: tx.Nonce(),
+			Transactions: []common.Hash{tx.Hash()}, txs: []*types.Transaction{tx}}, nil
+	}
+
+	nonces := watcher.observed[sender]
+	if nonces == nil {
+		nonces = map[uint64]observation{}
+		watcher.observed[sender] = nonces
+	}
+	previous, ok := nonces[tx.Nonce()]
+	if !ok {
+		nonces[tx.Nonce()] = observation{tx, block}
+		return
+	}
+	previousPayload, err := payloadHash(previous.tx)
+	if err != nil {
+		return
+	}
+	payload, err := payloadHash(tx)
+	if err != nil || payload == previousPayload {
+		return
+	}
+	return &Offense{Kind: OffenseRepeatedNonce, Relay: sender, Nonce: tx.Nonce(),
+		Transactions: []common.Hash{previous.tx.Hash(), tx.Hash()}, txs: []*types.Transaction{previous.tx, tx}}, nil
+}
+
+// isLegal returns whether tx calls RelayHub's registerRelay() or relayCall()
+func (watcher *PenalizationWatcher) isLegal(tx *types.Transaction) bool {
+	if tx.To() == nil || *tx.To() != watcher.RelayHubAddress || len(tx.Data()) < 4 {
+		return false
+	}
+	selector := tx.Data()[:4]
+	return bytes.Equal(selector, watcher.rhubABI.Methods["registerRelay"].ID) ||
+		bytes.Equal(selector, watcher.rhubABI.Methods["relayCall"].ID)
+}
+
+// updateChainID gets the chain id the transactions are signed for, as RelayServer.ChainID does
+func (watcher *PenalizationWatcher) updateChainID(ctx context.Context) (err error) {
+	if watcher.chainID == nil {
+		watcher.chainID, err = watcher.Client.NetworkID(ctx)
+	}
+	return
+}
+
+// updateRelays adds the relays staked from block start up to end (or the latest block if nil) to those the watcher
+// checks
+func (watcher *PenalizationWatcher) updateRelays(ctx context.Context, start uint64, end *uint64) (err error) {
+	iter, err := watcher.rhub.FilterStaked(&bind.FilterOpts{Start: start, End: end, Context: ctx}, nil)
+	if err != nil {
+		return
+	}
+	defer iter.Close()
+	for iter.Next() {
+		watcher.relays[iter.Event.Relay] = true
+	}
+	return iter.Error()
+}
+
+// prune forgets the transactions observed more than observationRetentionBlocks before lastBlock
+func (watcher *PenalizationWatcher) prune(lastBlock uint64) {
+	if lastBlock < observationRetentionBlocks {
+		return
+	}
+	for relay, nonces := range watcher.observed {
+		for nonce, observation := range nonces {
+			if observation.block < lastBlock-observationRetentionBlocks {
+				delete(nonces, nonce)
+			}
+		}
+		if len(nonces) == 0 {
+			delete(watcher.observed, relay)
+		}
+	}
+}
+
+// penalize sends the penalization of offense, if the relay can still be penalized
+func (watcher *PenalizationWatcher) penalize(ctx context.Context, offense *Offense) (err error) {
+	logger := watcher.Logger.New("relay", offense.Relay, "offense", offense.Kind, "nonce", offense.Nonce, "txHashes", offense.Transactions)
+	logger.Warn("PenalizationWatcher: relay offense found")
+
+	entry, err := watcher.rhub.GetRelay(&bind.CallOpts{Context: ctx}, offense.Relay)
+	if err != nil {
+		return
+	}
+	if entry.State != relayStateStaked && entry.State != relayStateRegistered && entry.State != relayStateRemoved {
+		logger.Info("PenalizationWatcher: relay cannot be penalized", "state", entry.State)
+		watcher.penalized[offense.Relay] = true
+		return
+	}
+
+	var proofs [][]byte
+	for _, tx := range offense.txs {
+		unsignedTx, signature, err := penalizationProof(tx, watcher.chainID)
+		if err != nil {
+			return err
+		}
+		proofs = append(proofs, unsignedTx, signature)
+	}
+	auth, err := bind.NewKeyedTransactorWithChainID(watcher.reporterKey, watcher.chainID)
+	if err != nil {
+		return
+	}
+	auth.Context = ctx
+
+	var tx *types.Transaction
+	switch offense.Kind {
+	case OffenseRepeatedNonce:
+		tx, err = watcher.rhub.PenalizeRepeatedNonce(auth, proofs[0], proofs[1], proofs[2], proofs[3])
+	case OffenseIllegalTransaction:
+		tx, err = watcher.rhub.PenalizeIllegalTransaction(auth, proofs[0], proofs[1])
+	}
+	if err != nil {
+		return
+	}
+	hash := tx.Hash()
+	offense.PenalizationTxHash = &hash
+	watcher.penalized[offense.Relay] = true
+	penalizations.WithLabelValues(offense.Kind).Inc()
+	logger.Warn("PenalizationWatcher: penalization sent", "penalizationTxHash", hash)
+	return
+}
+
+// penalizationProof returns the unsigned transaction, RLP encoded as it was hashed for signing, and the 65 bytes
+// signature that RelayHub recovers the relay's address from
+func penalizationProof(tx *types.Transaction, chainID *big.Int) (unsignedTx []byte, signature []byte, err error) {
+	fields := []interface{}{tx.Nonce(), tx.GasPrice(), tx.Gas(), tx.To(), tx.Value(), tx.Data()}
+	v, r, s := tx.RawSignatureValues()
+	recoveryID := new(big.Int).Sub(v, big.NewInt(27))
+	if tx.Protected() {
+		fields = append(fields, chainID, uint(0), uint(0))
+		recoveryID.Sub(v, new(big.Int).Add(new(big.Int).Mul(chainID, big.NewInt(2)), big.NewInt(35)))
+	}
+	unsignedTx, err = rlp.EncodeToBytes(fields)
+	if err != nil {
+		return
+	}
+
+	signature = make([]byte, 65)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:64])
+	signature[64] = byte(27 + recoveryID.Uint64())
+	return
+}
@@ -0,0 +1,83 @@
+package librelay
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"openeth.dev/librelay/test"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestPenalizationProof(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	chainID := big.NewInt(1337)
+	to := common.HexToAddress("ffcf8fdee72ac11b5c542428b35eef5769c409f0")
+
+	for name, signer := range map[string]types.Signer{"EIP155": types.NewEIP155Signer(chainID), "Homestead": types.HomesteadSigner{}} {
+		tx, err := types.SignTx(types.NewTransaction(3, to, big.NewInt(1), 50000, big.NewInt(10), []byte{1, 2}), signer, key)
+		test.ErrFail(err, t)
+		unsignedTx, signature, err := penalizationProof(tx, chainID)
+		test.ErrFail(err, t)
+
+		// As RelayHub does: keccak256(unsignedTx).recover(signature)
+		hash := crypto.Keccak256(unsignedTx)
+		if common.BytesToHash(hash) != signer.Hash(tx) {
+			t.Errorf("%v: unsigned tx hash %x is not the signing hash %v", name, hash, signer.Hash(tx).Hex())
+		}
+		if signature[64] != 27 && signature[64] != 28 {
+			t.Errorf("%v: wrong signature v %v", name, signature[64])
+		}
+		recoverable := append(append([]byte{}, signature[:64]...), signature[64]-27)
+		publicKey, err := crypto.SigToPub(hash, recoverable)
+		test.ErrFail(err, t)
+		if crypto.PubkeyToAddress(*publicKey) != address {
+			t.Errorf("%v: recovered %v instead of the signer %v", name, crypto.PubkeyToAddress(*publicKey).Hex(), address.Hex())
+		}
+	}
+}
+
+func TestPenalizationWatcherObserve(t *testing.T) {
+	relayKey, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	ownKey, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	otherKey, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	hub := common.HexToAddress("0xD216153c06E857cD7f72665E0aF1d7D82172F494")
+	watcher, err := NewPenalizationWatcher(nil, hub, otherKey, crypto.PubkeyToAddress(ownKey.PublicKey))
+	test.ErrFail(err, t)
+	watcher.chainID = big.NewInt(1337)
+	watcher.relays[crypto.PubkeyToAddress(relayKey.PublicKey)] = true
+	watcher.relays[crypto.PubkeyToAddress(ownKey.PublicKey)] = true
+
+	relayCall := append(append([]byte{}, watcher.rhubABI.Methods["relayCall"].ID...), 1, 2, 3)
+	signer := types.LatestSignerForChainID(watcher.chainID)
+
+	observe := func(name string, key *ecdsa.PrivateKey, txData types.TxData, expected string) {
+		tx, err := types.SignNewTx(key, signer, txData)
+		test.ErrFail(err, t)
+		offense, err := watcher.observe(tx, 10)
+		test.ErrFail(err, t)
+		kind := ""
+		if offense != nil {
+			kind = offense.Kind
+		}
+		if kind != expected {
+			t.Errorf("%v: expected offense %q but got %q", name, expected, kind)
+		}
+	}
+
+	observe("relayCall", relayKey, &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(10), Gas: 50000, To: &hub, Value: big.NewInt(0), Data: relayCall}, "")
+	observe("gas price bump", relayKey, &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(20), Gas: 50000, To: &hub, Value: big.NewInt(0), Data: relayCall}, "")
+	observe("repeated nonce", relayKey, &types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(10), Gas: 60000, To: &hub, Value: big.NewInt(0), Data: relayCall}, OffenseRepeatedNonce)
+	observe("transfer", relayKey, &types.LegacyTx{Nonce: 2, GasPrice: big.NewInt(10), Gas: 21000, To: &common.Address{1}, Value: big.NewInt(1)}, OffenseIllegalTransaction)
+	observe("other hub method", relayKey, &types.LegacyTx{Nonce: 3, GasPrice: big.NewInt(10), Gas: 50000, To: &hub, Value: big.NewInt(0), Data: watcher.rhubABI.Methods["unstake"].ID}, OffenseIllegalTransaction)
+	observe("not a relay", otherKey, &types.LegacyTx{Nonce: 2, GasPrice: big.NewInt(10), Gas: 21000, To: &common.Address{1}, Value: big.NewInt(1)}, "")
+	observe("own relay", ownKey, &types.LegacyTx{Nonce: 2, GasPrice: big.NewInt(10), Gas: 21000, To: &common.Address{1}, Value: big.NewInt(1)}, "")
+	observe("typed tx", relayKey, &types.DynamicFeeTx{ChainID: watcher.chainID, Nonce: 4, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(10), Gas: 21000, To: &common.Address{1}, Value: big.NewInt(1)}, "")
+}
@@ -0,0 +1,169 @@
+package librelay
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"openeth.dev/librelay/txstore"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ReconciliationReport describes how ReconcileTxStore brought the TxStore in line with the chain
+type ReconciliationReport struct {
+	ConfirmedNonce uint64        // the account's nonce on the latest block
+	PendingNonce   uint64        // the account's nonce including the node's pending transactions
+	Stored         int           // transactions stored before reconciliation
+	Mined          []uint64      // nonces of stored transactions mined on chain
+	Replaced       []uint64      // nonces of stored transactions replaced on chain by another one, which were dropped
+	Gaps           []uint64      // nonces missing from the TxStore, filled with registerRelay() calls
+	GapFillers     []common.Hash // hashes of the gap fillers sent, in the order of Gaps
+}
+
+func (report *ReconciliationReport) Dump(logger Logger) {
+	logger.Info("TxStore reconciliation",
+		"confirmedNonce", report.ConfirmedNonce,
+		"pendingNonce", report.PendingNonce,
+		"stored", report.Stored,
+		"mined", report.Mined,
+		"replaced", report.Replaced,
+		"gaps", report.Gaps,
+		"gapFillers", report.GapFillers)
+}
+
+// ReconcileTxStore compares the stored transactions with the account's confirmed and pending nonces. Stored
+// transactions of which no attempt was mined although their nonce was used are dropped, and nonce gaps, which would
+// stall every later transaction, are filled. Does nothing on dev mode, as the chain may be reverted.
+func (relay *RelayServer) ReconcileTxStore(ctx context.Context) (report *ReconciliationReport, err error) {
+	report = &ReconciliationReport{}
+	if relay.DevMode {
+		return
+	}
+
+	report.ConfirmedNonce, err = relay.Client.NonceAt(ctx, relay.Address(), nil)
+	if err != nil {
+		return
+	}
+	report.PendingNonce, err = relay.Client.PendingNonceAt(ctx, relay.Address())
+	if err != nil {
+		return
+	}
+
+	txs, err := relay.TxStore.ListTransactions()
+	if err != nil {
+		return
+	}
+	report.Stored = len(txs)
+
+	for _, tx := range txs {
+		if tx.Nonce() >= report.ConfirmedNonce {
+			break
+		}
+		mined, err := relay.anyAttemptMined(ctx, tx.Attempts)
+		if err != nil {
+			return report, err
+		}
+		if mined {
+			report.Mined = append(report.Mined, tx.Nonce())
+			continue
+		}
+		relay.Logger.Warn("Stored transaction replaced on chain", "nonce", tx.Nonce(), "txHash", tx.Hash())
+		err = relay.TxStore.RemoveTransactionByNonce(tx.Nonce())
+		if err != nil {
+			return report, err
+		}
+		report.Replaced = append(report.Replaced, tx.Nonce())
+	}
+	relay.updatePendingTransactionsGauge()
+
+	report.Gaps, report.GapFillers, err = relay.FillNonceGaps(ctx)
+	return
+}
+
+// FillNonceGaps sends a transaction for each of the NonceManager's Gaps, the nonces no transaction is known for,
+// which stall the later transactions in the node's queue. Returns the gaps and the hashes of the transactions filling
+// them, which may be fewer on error.
+func (relay *RelayServer) FillNonceGaps(ctx context.Context) (gaps []uint64, fillers []common.Hash, err error) {
+	// Gaps are only filled once, even if the jobs reconciling and updating the TxStore look for them concurrently
+	relay.gapsMutex.Lock()
+	defer relay.gapsMutex.Unlock()
+
+	gaps, err = relay.nonceManager.Gaps(ctx)
+	if err != nil {
+		return
+	}
+	for _, nonce := range gaps {
+		hash, err := relay.fillNonceGap(ctx, nonce)
+		if err != nil {
+			return gaps, fillers, err
+		}
+		fillers = append(fillers, hash)
+	}
+	return
+}
+
+// anyAttemptMined returns whether the receipt of any of attempts is available
+func (relay *RelayServer) anyAttemptMined(ctx context.Context, attempts []txstore.TransactionAttempt) (mined bool, err error) {
+	for _, attempt := range attempts {
+		_, err = relay.Client.TransactionReceipt(ctx, attempt.Hash)
+		if err == nil {
+			return true, nil
+		}
+		if err != ethereum.NotFound {
+			return
+		}
+	}
+	return false, nil
+}
+
+// Gas limit of gap fillers when their gas cannot be estimated, because the relay is not staked so they revert
+const nonceGapFillerGasLimit = 200000
+
+// fillNonceGap sends a registerRelay() call with the given nonce, so the later transactions can be mined. Any other
+// transaction, including a plain transfer, could be penalized through RelayHub's penalizeIllegalTransaction, and it
+// consumes the nonce even if it reverts.
+func (relay *RelayServer) fillNonceGap(ctx context.Context, nonce uint64) (hash common.Hash, err error) {
+	logger := relay.Logger.New("nonce", nonce)
+	if relay.gasPrice == nil && relay.DynamicFees() == nil {
+		return hash, fmt.Errorf("cannot fill nonce gap %d before the gas price is known", nonce)
+	}
+	chainID, err := relay.ChainID(ctx)
+	if err != nil {
+		return
+	}
+
+	data, err := relay.rhubABI.Pack("registerRelay", relay.BaseFee, relay.PercentFee, relay.Url)
+	if err != nil {
+		return
+	}
+	gasLimit, err := relay.Client.EstimateGas(ctx, ethereum.CallMsg{From: relay.Address(), To: &relay.RelayHubAddress, Data: data})
+	if err != nil {
+		logger.Warn("Error estimating gas of nonce gap filler", "err", err)
+		gasLimit = nonceGapFillerGasLimit
+	}
+
+	err = relay.nonceManager.ReserveNonce(ctx, nonce)
+	if err != nil {
+		return
+	}
+	tx := relay.newPlainTransaction(chainID, nonce, relay.RelayHubAddress, big.NewInt(0), gasLimit, relay.gasPrice, data)
+	signedTx, err := relay.signTransaction(ctx, tx, chainID)
+	if err != nil {
+		relay.nonceManager.Release(nonce)
+		logger.Error("Error signing nonce gap filler", "err", err)
+		return
+	}
+	stored, err := relay.broadcastTransaction(ctx, logger, "nonce gap filler", signedTx)
+	if !stored {
+		relay.nonceManager.Release(nonce)
+		return
+	}
+	relay.nonceManager.Commit(nonce)
+	if err != nil {
+		return
+	}
+
+	logger.Info("Nonce gap filled", "txHash", signedTx.Hash())
+	return signedTx.Hash(), nil
+}
@@ -0,0 +1,88 @@
+package librelay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"openeth.dev/gen/librelay"
+	"openeth.dev/librelay/test"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// reconcileClient is a broadcastClient which also knows the pending nonce, the chain id and the mined transactions
+type reconcileClient struct {
+	*broadcastClient
+	pendingNonce uint64
+	mined        map[common.Hash]bool
+}
+
+func (client *reconcileClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return client.pendingNonce, nil
+}
+
+func (client *reconcileClient) NetworkID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1337), nil
+}
+
+func (client *reconcileClient) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	if client.mined[hash] {
+		return &types.Receipt{TxHash: hash}, nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (client *reconcileClient) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return 0, errors.New("execution reverted")
+}
+
+func TestReconcileTxStore(t *testing.T) {
+	// Tx 1 was mined after being replaced, tx 2 was replaced by a tx we did not store, and 4 was never stored
+	original, replacement, replaced, pending := newNonceTx(1), newNonceTx(1), newNonceTx(2), newNonceTx(5)
+	client := &reconcileClient{
+		broadcastClient: &broadcastClient{accountNonce: 3},
+		pendingNonce:    4,
+		mined:           map[common.Hash]bool{original.Hash(): true},
+	}
+	relay := newBroadcastRelay(t, client.broadcastClient)
+	relay.Client = client
+	relay.nonceManager = NewNonceManager(relay.Address(), client, relay.TxStore, relay.Logger)
+	relay.gasPrice = big.NewInt(1)
+	relay.BaseFee, relay.PercentFee = big.NewInt(0), big.NewInt(70)
+	relay.RelayHubAddress = common.HexToAddress("0xD216153c06E857cD7f72665E0aF1d7D82172F494")
+	var err error
+	relay.rhubABI, err = abi.JSON(strings.NewReader(librelay.IRelayHubABI))
+	test.ErrFail(err, t)
+	test.ErrFail(relay.TxStore.SaveTransaction(original), t)
+	test.ErrFail(relay.TxStore.UpdateTransactionByNonce(replacement), t)
+	test.ErrFail(relay.TxStore.SaveTransaction(replaced), t)
+	test.ErrFail(relay.TxStore.SaveTransaction(pending), t)
+
+	report, err := relay.ReconcileTxStore(context.Background())
+	test.ErrFail(err, t)
+	if report.Stored != 3 || !reflect.DeepEqual(report.Mined, []uint64{1}) || !reflect.DeepEqual(report.Replaced, []uint64{2}) ||
+		!reflect.DeepEqual(report.Gaps, []uint64{4}) || len(report.GapFillers) != 1 {
+		t.Fatalf("Wrong report %+v", report)
+	}
+
+	if len(client.sent) != 1 || client.sent[0].Nonce() != 4 || *client.sent[0].To() != relay.RelayHubAddress ||
+		!bytes.Equal(client.sent[0].Data()[:4], relay.rhubABI.Methods["registerRelay"].ID) || client.sent[0].Gas() != nonceGapFillerGasLimit {
+		t.Fatalf("Expected a registerRelay() call filling nonce 4 but sent %v", client.sent)
+	}
+	txs, err := relay.TxStore.ListTransactions()
+	test.ErrFail(err, t)
+	nonces := []uint64{}
+	for _, tx := range txs {
+		nonces = append(nonces, tx.Nonce())
+	}
+	if !reflect.DeepEqual(nonces, []uint64{1, 4, 5}) {
+		t.Errorf("Expected txs 1, 4 and 5 to be stored but got %v", nonces)
+	}
+}
@@ -0,0 +1,94 @@
+package librelay
+
+import (
+	"fmt"
+	"math/big"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Sources of the relay's settings, by increasing precedence
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "file" // the config file
+	ConfigSourceEnv     = "env"  // the GSN_* environment variables, see ConfigEnvName
+	ConfigSourceFlag    = "flag" // the command line
+)
+
+// ConfigSetting is the value of a setting, as it was given, and its ConfigSource*
+type ConfigSetting struct {
+	Value  string
+	Source string
+}
+
+func (setting ConfigSetting) String() string {
+	return fmt.Sprintf("%s (%s)", setting.Value, setting.Source)
+}
+
+// Upper bounds of the fee and gas price percents. RelayHub has none, these only catch mistakes such as a fee given in
+// basis points, which would price the relay out or overpay every transaction.
+const (
+	MaxPercentFee      = 1000
+	MaxGasPricePercent = 1000
+)
+
+// Validate checks every setting, returning all the invalid ones
+func (relayParams *RelayParams) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	u, err := url.Parse(relayParams.Url)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "Url %q is not an http(s) url", relayParams.Url)
+	port, err := strconv.Atoi(relayParams.Port)
+	check(err == nil && port > 0 && port < 65536, "Port %q is not a port number", relayParams.Port)
+	node, err := url.Parse(relayParams.EthereumNodeURL)
+	check(err == nil && relayParams.EthereumNodeURL != "" && (node.Scheme != "" || node.Path != ""), "EthereumNodeUrl %q is not a url or IPC path", relayParams.EthereumNodeURL)
+	check(relayParams.RelayHubAddress != (common.Address{}), "RelayHubAddress is zero")
+
+	check(relayParams.BaseFee != nil && relayParams.BaseFee.Sign() >= 0, "BaseFee %v is negative", relayParams.BaseFee)
+	check(relayParams.PercentFee != nil && relayParams.PercentFee.Sign() >= 0 && relayParams.PercentFee.Cmp(big.NewInt(MaxPercentFee)) <= 0,
+		"PercentFee %v is not between 0 and %d", relayParams.PercentFee, MaxPercentFee)
+	check(relayParams.GasPricePercent != nil && relayParams.GasPricePercent.Cmp(big.NewInt(-100)) > 0 && relayParams.GasPricePercent.Cmp(big.NewInt(MaxGasPricePercent)) <= 0,
+		"GasPricePercent %v is not above -100 and up to %d", relayParams.GasPricePercent, MaxGasPricePercent)
+	check(relayParams.DefaultGasPrice > 0, "DefaultGasPrice %d is not positive", relayParams.DefaultGasPrice)
+	check(relayParams.RegistrationBlockRate > 0, "RegistrationBlockRate is zero")
+	check(relayParams.DBFile != "" && relayParams.SignedNoncesDBFile != "", "database paths are empty")
+
+	if _, err = NewGasPriceOracle(relayParams.GasPriceOracleConfig, nil); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err = NewResendPolicy(relayParams.ResendPolicyConfig); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err = relayParams.SignerConfig.validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// dumpSettings logs every setting's value with its source, sorted by name
+func (relayParams *RelayParams) dumpSettings() {
+	names := make([]string, 0, len(relayParams.Settings))
+	for name := range relayParams.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ctx := make([]interface{}, 0, 2*len(names))
+	for _, name := range names {
+		ctx = append(ctx, name, relayParams.Settings[name].String())
+	}
+	log.Info("Relay initial configuration", ctx...)
+}
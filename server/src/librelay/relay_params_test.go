@@ -0,0 +1,73 @@
+package librelay
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func validRelayParams() RelayParams {
+	relayParams := RelayParams{
+		DBFile:               "db",
+		SignedNoncesDBFile:   "signed-nonces",
+		GasPriceOracleConfig: GasPriceOracleConfig{Type: NodeGasPriceOracleType},
+		ResendPolicyConfig:   DefaultResendPolicyConfig(),
+		SignerConfig:         SignerConfig{Type: LocalSignerType},
+	}
+	relayParams.Url = "https://relay.example.com"
+	relayParams.Port = "8090"
+	relayParams.EthereumNodeURL = "http://localhost:8545"
+	relayParams.RelayHubAddress = common.HexToAddress("0x49a984490a7762B0e5d775f0FfA608899Ebe2ee8")
+	relayParams.BaseFee = big.NewInt(0)
+	relayParams.PercentFee = big.NewInt(70)
+	relayParams.GasPricePercent = big.NewInt(-99)
+	relayParams.DefaultGasPrice = 1e9
+	relayParams.RegistrationBlockRate = 1000
+	return relayParams
+}
+
+func TestRelayParamsValidate(t *testing.T) {
+	relayParams := validRelayParams()
+	if err := relayParams.Validate(); err != nil {
+		t.Fatalf("Expected valid params, got %v", err)
+	}
+
+	for name, invalidate := range map[string]func(*RelayParams){
+		"Url":                   func(p *RelayParams) { p.Url = "localhost:8090" },
+		"Port":                  func(p *RelayParams) { p.Port = "http" },
+		"EthereumNodeUrl":       func(p *RelayParams) { p.EthereumNodeURL = "" },
+		"RelayHubAddress":       func(p *RelayParams) { p.RelayHubAddress = common.Address{} },
+		"BaseFee":               func(p *RelayParams) { p.BaseFee = big.NewInt(-1) },
+		"PercentFee":            func(p *RelayParams) { p.PercentFee = big.NewInt(-1) },
+		"GasPricePercent":       func(p *RelayParams) { p.GasPricePercent = big.NewInt(-100) },
+		"high PercentFee":       func(p *RelayParams) { p.PercentFee = big.NewInt(MaxPercentFee + 1) },
+		"high GasPricePercent":  func(p *RelayParams) { p.GasPricePercent = big.NewInt(MaxGasPricePercent + 1) },
+		"DefaultGasPrice":       func(p *RelayParams) { p.DefaultGasPrice = 0 },
+		"RegistrationBlockRate": func(p *RelayParams) { p.RegistrationBlockRate = 0 },
+		"gas price oracle":      func(p *RelayParams) { p.GasPriceOracleConfig.Type = "unknown" },
+		"resend policy":         func(p *RelayParams) { p.ResendPolicyConfig.Percent = -1 },
+		"signer":                func(p *RelayParams) { p.SignerConfig.Type = RemoteSignerType },
+	} {
+		invalid := validRelayParams()
+		invalidate(&invalid)
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected an invalid %s to be refused", name)
+		}
+	}
+
+	invalid := validRelayParams()
+	invalid.Port = ""
+	invalid.BaseFee = big.NewInt(-1)
+	if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), "Port") || !strings.Contains(err.Error(), "BaseFee") {
+		t.Errorf("Expected every invalid setting to be reported, got %v", err)
+	}
+}
+
+func TestConfigSettingString(t *testing.T) {
+	setting := ConfigSetting{Value: "70", Source: ConfigSourceEnv}
+	if setting.String() != "70 (env)" {
+		t.Errorf("Expected value and source, got %q", setting.String())
+	}
+}
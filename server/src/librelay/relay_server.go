@@ -5,11 +5,10 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"math/big"
 	"openeth.dev/gen/librelay"
-	"openeth.dev/gen/testcontracts"
 	"openeth.dev/librelay/txstore"
 	"strings"
 	"sync"
@@ -18,18 +17,31 @@ import (
 	"code.cloudfoundry.org/clock"
 
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 const TxReceiptTimeout = 60 * time.Second
 
-var lastNonce uint64 = 0
-var nonceMutex = &sync.Mutex{}
+// GasReserve mirrors RelayHub's GAS_RESERVE: gas that must be left for relayCall() on top of the max possible gas
+const GasReserve = 100000
+
+// RelayCallPreCheckGas bounds the gas relayCall() spends before RelayHub checks that GasReserve and the required gas
+// are left: dispatching and decoding the calldata, loading the relay's state and calling the paymaster's
+// getGasLimits(). TestRelayCallPreCheckGas measures it against the deployed RelayHub and TestSponsor; the bound leaves
+// room for paymasters whose getGasLimits() costs more. Unused gas is refunded, but the relay's balance must cover the
+// whole gas limit at the transaction's gas price, including the bumped price of a resent transaction.
+const RelayCallPreCheckGas = 100000
+
+// relayHubGasABI declares RelayHub's public gtxdatanonzero, which IRelayHub lacks: RelayHub charges paymasters for
+// every calldata byte at that price, rather than the network's
+const relayHubGasABI = `[{"constant":true,"inputs":[],"name":"gtxdatanonzero","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]`
 
 type RelayTransactionRequest struct {
 	EncodedFunction string
@@ -56,10 +68,13 @@ type AuditRelaysRequest struct {
 }
 
 type GetEthAddrResponse struct {
-	RelayServerAddress common.Address
-	MinGasPrice        big.Int
-	Ready              bool
-	Version            string
+	RelayServerAddress   common.Address
+	MinGasPrice          big.Int
+	BaseFeePerGas        big.Int // EIP-1559 fee parameters, zero if the chain does not support them
+	MaxFeePerGas         big.Int
+	MaxPriorityFeePerGas big.Int
+	Ready                bool
+	Version              string
 }
 
 type RelayTransactionResponse struct {
@@ -68,39 +83,44 @@ type RelayTransactionResponse struct {
 }
 
 func (response *RelayTransactionResponse) MarshalJSON() ([]byte, error) {
-	_ = testcontracts.TestSponsor{} // TODO: do not keep, testing build
+	rawTxBytes, err := response.SignedTx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
 
 	return json.Marshal(struct {
 		SignedTx   *types.Transaction
 		RawTxBytes []byte
 	}{
 		SignedTx:   response.SignedTx,
-		RawTxBytes: types.Transactions{response.SignedTx}.GetRlp(0),
+		RawTxBytes: rawTxBytes,
 	})
 }
 
 type IRelay interface {
-	Balance() (balance *big.Int, err error)
+	Balance(ctx context.Context) (balance *big.Int, err error)
 
 	GasPrice() big.Int
 
-	RefreshGasPrice() (err error)
+	DynamicFees() (fees *DynamicFees)
+
+	RefreshGasPrice(ctx context.Context) (err error)
 
-	RegisterRelay() (err error)
+	RegisterRelay(ctx context.Context) (err error)
 
-	IsStaked() (staked bool, err error)
+	IsStaked(ctx context.Context) (staked bool, err error)
 
-	IsUnstaked() (removed bool, err error)
+	IsUnstaked(ctx context.Context) (removed bool, err error)
 
-	BlockCountSinceLastEvent() (when uint64, err error)
+	BlockCountSinceLastEvent(ctx context.Context) (when uint64, err error)
 
 	GetRegistrationBlockRate() (rate uint64)
 
-	IsRemoved() (removed bool, err error)
+	IsRemoved(ctx context.Context) (removed bool, err error)
 
-	SendBalanceToOwner() (err error)
+	SendBalanceToOwner(ctx context.Context) (err error)
 
-	CreateRelayTransaction(request RelayTransactionRequest) (signedTx *types.Transaction, err error)
+	CreateRelayTransaction(ctx context.Context, request RelayTransactionRequest, requestID string) (signedTx *types.Transaction, err error)
 
 	Address() (relayAddress common.Address)
 
@@ -110,13 +130,23 @@ type IRelay interface {
 
 	GetPort() string
 
-	UpdateUnconfirmedTransactions() (newTx *types.Transaction, err error)
+	UpdateUnconfirmedTransactions(ctx context.Context) (newTxs []*types.Transaction, err error)
+
+	TransactionStatusByHash(ctx context.Context, hash common.Hash) (status *TransactionStatus, err error)
+
+	TransactionStatusByNonce(ctx context.Context, nonce uint64) (status *TransactionStatus, err error)
+
+	Health(ctx context.Context) (health *Health)
+
+	ReconcileTxStore(ctx context.Context) (report *ReconciliationReport, err error)
+
+	FillNonceGaps(ctx context.Context) (gaps []uint64, fillers []common.Hash, err error)
 
 	Close() (err error)
 
-	sendRegisterTransaction() (tx *types.Transaction, err error)
+	sendRegisterTransaction(ctx context.Context) (tx *types.Transaction, err error)
 
-	awaitTransactionMined(tx *types.Transaction) (err error)
+	awaitTransactionMined(ctx context.Context, tx *types.Transaction) (err error)
 }
 
 type IClient interface {
@@ -124,6 +154,7 @@ type IClient interface {
 	ethereum.TransactionReader
 
 	NetworkID(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
 
 	//From: ChainReader
 	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
@@ -144,39 +175,59 @@ type RelayServer struct {
 	RelayHubAddress       common.Address
 	DefaultGasPrice       int64
 	GasPricePercent       *big.Int
-	PrivateKey            *ecdsa.PrivateKey
+	GasPriceOracle        GasPriceOracle
+	ResendPolicy          *ResendPolicy
+	Signer                Signer
 	RegistrationBlockRate uint64
 	EthereumNodeURL       string
-	gasPrice              *big.Int // set dynamically as suggestedGasPrice*(GasPricePercent+100)/100
+	gasPrice              *big.Int // set dynamically as GasPriceOracle's suggestion*(GasPricePercent+100)/100
+	dynamicFees           *DynamicFees
 	Client                IClient
 	chainID               *big.Int
 	TxStore               txstore.ITxStore
 	rhub                  *librelay.IRelayHub
+	rhubABI               abi.ABI
+	rhubGas               *bind.BoundContract
+	nonceManager          *NonceManager
+	SigningGuard          *SigningGuard
+	gapsMutex             *sync.Mutex // held while filling nonce gaps
 	clock                 clock.Clock
 	DevMode               bool
+	Logger                Logger
 }
 
 type RelayParams struct {
 	RelayServer
-	DBFile string
+	DBFile               string
+	SignedNoncesDBFile   string
+	GasPriceOracleConfig GasPriceOracleConfig
+	ResendPolicyConfig   ResendPolicyConfig
+	SignerConfig         SignerConfig
+	Settings             map[string]ConfigSetting // every setting as given, by flag name
 }
 
+// Dump logs the configuration, as the Settings it was parsed from if they are known
 func (relayParams *RelayParams) Dump() {
-
-	log.Println("Relay initial configuration:")
-	log.Println("OwnerAddress:", relayParams.OwnerAddress.String())
-	log.Println("BaseFee:", relayParams.BaseFee.String())
-	log.Println("PercentFee:", relayParams.PercentFee.String())
-	log.Println("Url:", relayParams.Url)
-	log.Println("Port:", relayParams.Port)
-	log.Println("RelayHubAddress:", relayParams.RelayHubAddress.String())
-	log.Println("DefaultGasPrice:", relayParams.DefaultGasPrice)
-	log.Println("GasPricePercent:", relayParams.GasPricePercent.String())
-	log.Println("RegistrationBlockRate:", relayParams.RegistrationBlockRate)
-	log.Println("EthereumNodeUrl:", relayParams.EthereumNodeURL)
-	if relayParams.DevMode {
-		log.Println("Using dev mode")
+	if relayParams.Settings != nil {
+		relayParams.dumpSettings()
+		return
 	}
+
+	log.Info("Relay initial configuration",
+		"OwnerAddress", relayParams.OwnerAddress,
+		"BaseFee", relayParams.BaseFee,
+		"PercentFee", relayParams.PercentFee,
+		"Url", relayParams.Url,
+		"Port", relayParams.Port,
+		"RelayHubAddress", relayParams.RelayHubAddress,
+		"DefaultGasPrice", relayParams.DefaultGasPrice,
+		"GasPricePercent", relayParams.GasPricePercent,
+		"RegistrationBlockRate", relayParams.RegistrationBlockRate,
+		"EthereumNodeUrl", relayParams.EthereumNodeURL,
+		"DevMode", relayParams.DevMode)
+	relayParams.GasPriceOracleConfig.Dump()
+	relayParams.ResendPolicyConfig.Dump()
+	relayParams.SignerConfig.Dump()
 }
 
 func NewEthClient(EthereumNodeURL string, defaultGasPrice int64) (IClient, error) {
@@ -195,23 +246,44 @@ func NewRelayServer(
 	RelayHubAddress common.Address,
 	DefaultGasPrice int64,
 	GasPricePercent *big.Int,
-	PrivateKey *ecdsa.PrivateKey,
+	Signer Signer,
 	RegistrationBlockRate uint64,
 	EthereumNodeURL string,
 	Client IClient,
 	TxStore txstore.ITxStore,
+	SignedNonceStore txstore.ISignedNonceStore,
 	clk clock.Clock,
 	DevMode bool) (*RelayServer, error) {
 
+	// Without a durable record of the signed nonces, a restart could sign another payload with one of them
+	if SignedNonceStore == nil {
+		return nil, errors.New("a signed nonce store is required")
+	}
+
 	rhub, err := librelay.NewIRelayHub(RelayHubAddress, Client)
 	if err != nil {
 		return nil, err
 	}
 
+	rhubABI, err := abi.JSON(strings.NewReader(librelay.IRelayHubABI))
+	if err != nil {
+		return nil, err
+	}
+
+	rhubGasABI, err := abi.JSON(strings.NewReader(relayHubGasABI))
+	if err != nil {
+		return nil, err
+	}
+
 	if clk == nil {
 		clk = clock.NewClock()
 	}
 
+	resendPolicy, err := NewResendPolicy(DefaultResendPolicyConfig())
+	if err != nil {
+		return nil, err
+	}
+
 	relay := &RelayServer{
 		OwnerAddress:          OwnerAddress,
 		PercentFee:            PercentFee,
@@ -221,39 +293,50 @@ func NewRelayServer(
 		RelayHubAddress:       RelayHubAddress,
 		DefaultGasPrice:       DefaultGasPrice,
 		GasPricePercent:       GasPricePercent,
-		PrivateKey:            PrivateKey,
+		GasPriceOracle:        &NodeGasPriceOracle{Client: Client},
+		ResendPolicy:          resendPolicy,
+		Signer:                Signer,
 		RegistrationBlockRate: RegistrationBlockRate,
 		EthereumNodeURL:       EthereumNodeURL,
 		Client:                Client,
 		TxStore:               TxStore,
 		rhub:                  rhub,
+		rhubABI:               rhubABI,
+		rhubGas:               bind.NewBoundContract(RelayHubAddress, rhubGasABI, Client, Client, Client),
 		clock:                 clk,
 		DevMode:               DevMode,
+		Logger:                log.Root(),
+		gapsMutex:             &sync.Mutex{},
+		SigningGuard:          NewSigningGuard(SignedNonceStore, DevMode),
 	}
+	relay.nonceManager = NewNonceManager(relay.Address(), Client, TxStore, relay.Logger)
 	return relay, err
 }
 
-func (relay *RelayServer) ChainID() (chainID *big.Int, err error) {
+func (relay *RelayServer) ChainID(ctx context.Context) (chainID *big.Int, err error) {
 	if relay.chainID != nil {
 		return relay.chainID, nil
 	}
 
-	chainID, err = relay.Client.NetworkID(context.Background())
+	chainID, err = relay.Client.NetworkID(ctx)
 	if err != nil {
-		log.Println("ChainID() failed", err)
+		relay.Logger.Error("ChainID() failed", "err", err)
 		return
 	}
 
 	if relay.DevMode && chainID.Int64() < 1000 {
-		log.Fatalf("Cowardly refusing to connect to chain with ID=%s in DevMode. Only chains with ID 1000 or higher are supported for dev mode to prevent the relay from being accidentally penalized.", chainID.String())
+		relay.Logger.Crit("Cowardly refusing to connect to chain in DevMode. Only chains with ID 1000 or higher are supported for dev mode to prevent the relay from being accidentally penalized.", "chainID", chainID)
 	}
 
 	relay.chainID = chainID
 	return
 }
 
-func (relay *RelayServer) Balance() (balance *big.Int, err error) {
-	balance, err = relay.Client.BalanceAt(context.Background(), relay.Address(), nil)
+func (relay *RelayServer) Balance(ctx context.Context) (balance *big.Int, err error) {
+	balance, err = relay.Client.BalanceAt(ctx, relay.Address(), nil)
+	if err == nil {
+		relay.setWeiGauge(balanceGauge, balance)
+	}
 	return
 }
 
@@ -264,85 +347,105 @@ func (relay *RelayServer) GasPrice() big.Int {
 	return *relay.gasPrice
 }
 
-func (relay *RelayServer) RefreshGasPrice() (err error) {
-	gasPrice, err := relay.Client.SuggestGasPrice(context.Background())
+func (relay *RelayServer) RefreshGasPrice(ctx context.Context) (err error) {
+	gasPrice, err := relay.GasPriceOracle.SuggestGasPrice(ctx)
 	if err != nil {
-		log.Println("SuggestGasPrice() failed ", err)
+		relay.Logger.Error("SuggestGasPrice() failed", "err", err)
 		return
 	}
-	relay.gasPrice = gasPrice.Mul(big.NewInt(0).Add(relay.GasPricePercent, big.NewInt(100)), gasPrice).Div(gasPrice, big.NewInt(100))
+	gasPrice = new(big.Int).Set(gasPrice)
+	gasPrice.Mul(big.NewInt(0).Add(relay.GasPricePercent, big.NewInt(100)), gasPrice).Div(gasPrice, big.NewInt(100))
+
+	fees, err := relay.suggestDynamicFees(ctx)
+	if err != nil {
+		relay.Logger.Error("suggestDynamicFees() failed", "err", err)
+		return
+	}
+	if fees != nil {
+		relay.Logger.Debug("Dynamic fees", "baseFee", fees.BaseFee, "maxPriorityFeePerGas", fees.MaxPriorityFeePerGas, "maxFeePerGas", fees.MaxFeePerGas)
+		// Requests must pay at least the base fee and our priority fee, as their gas price becomes both our fee caps
+		minGasPrice := new(big.Int).Add(fees.BaseFee, fees.MaxPriorityFeePerGas)
+		if gasPrice.Cmp(minGasPrice) < 0 {
+			gasPrice = minGasPrice
+		}
+	}
+	relay.gasPrice = gasPrice
+	relay.dynamicFees = fees
+	relay.setWeiGauge(gasPriceGauge, gasPrice)
 	return
 }
 
-func (relay *RelayServer) RegisterRelay() (err error) {
-	tx, err := relay.sendRegisterTransaction()
+func (relay *RelayServer) RegisterRelay(ctx context.Context) (err error) {
+	tx, err := relay.sendRegisterTransaction(ctx)
 	if err != nil {
 		return err
 	}
-	return relay.awaitTransactionMined(tx)
+	return relay.awaitTransactionMined(ctx, tx)
 }
 
-func (relay *RelayServer) sendRegisterTransaction() (tx *types.Transaction, err error) {
+func (relay *RelayServer) sendRegisterTransaction(ctx context.Context) (tx *types.Transaction, err error) {
 	desc := fmt.Sprintf("RegisterRelay(address=%s, url=%s)", relay.RelayHubAddress.Hex(), relay.Url)
-	tx, err = relay.sendDataTransaction(desc, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+	tx, err = relay.sendDataTransaction(ctx, relay.Logger, desc, func(auth *bind.TransactOpts) (*types.Transaction, error) {
 		return relay.rhub.RegisterRelay(auth, relay.BaseFee, relay.PercentFee, relay.Url)
 	})
 	return
 }
 
-func (relay *RelayServer) RemoveRelay(ownerKey *ecdsa.PrivateKey) (err error) {
-	tx, err := relay.sendRemoveTransaction(ownerKey)
+func (relay *RelayServer) RemoveRelay(ctx context.Context, ownerKey *ecdsa.PrivateKey) (err error) {
+	tx, err := relay.sendRemoveTransaction(ctx, ownerKey)
 	if err != nil {
 		return err
 	}
-	return relay.awaitTransactionMined(tx)
+	return relay.awaitTransactionMined(ctx, tx)
 }
 
-func (relay *RelayServer) sendRemoveTransaction(ownerKey *ecdsa.PrivateKey) (tx *types.Transaction, err error) {
+func (relay *RelayServer) sendRemoveTransaction(ctx context.Context, ownerKey *ecdsa.PrivateKey) (tx *types.Transaction, err error) {
 	auth := bind.NewKeyedTransactor(ownerKey)
+	auth.Context = ctx
 	desc := fmt.Sprintf("RemoveRelayByOwner(address=%s)", relay.Address())
-	log.Println(desc, "tx sending")
+	relay.Logger.Info("Sending transaction", "desc", desc)
 
 	tx, err = relay.rhub.RemoveRelayByOwner(auth, relay.Address())
 	if err != nil {
-		log.Println(desc, "error sending tx:", err)
+		relay.Logger.Error("Error sending transaction", "desc", desc, "err", err)
 		return
 	}
-	log.Println(desc, "tx sent:", tx.Hash().Hex())
+	relay.Logger.Info("Transaction sent", "desc", desc, "nonce", tx.Nonce(), "txHash", tx.Hash())
 	return
 }
 
-func (relay *RelayServer) IsStaked() (staked bool, err error) {
+func (relay *RelayServer) IsStaked(ctx context.Context) (staked bool, err error) {
 	relayAddress := relay.Address()
 	callOpt := &bind.CallOpts{
 		From:    relayAddress,
 		Pending: false,
+		Context: ctx,
 	}
 
 	stakeEntry, err := relay.rhub.GetRelay(callOpt, relayAddress)
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("Error getting relay's stake", "err", err)
 		return
 	}
 	staked = (stakeEntry.TotalStake.Cmp(big.NewInt(0)) != 0)
+	relay.setWeiGauge(stakeGauge, stakeEntry.TotalStake)
 
 	if staked && (relay.OwnerAddress.Hex() == common.HexToAddress("0").Hex()) {
-		log.Println("Got staked for the first time, setting owner")
 		relay.OwnerAddress = stakeEntry.Owner
-		log.Println("Owner is", relay.OwnerAddress.Hex())
-		log.Println("Stake:", stakeEntry.TotalStake.String())
+		relay.Logger.Info("Got staked for the first time, setting owner", "owner", relay.OwnerAddress, "stake", stakeEntry.TotalStake)
 	}
 	return
 }
 
-func (relay *RelayServer) IsUnstaked() (removed bool, err error) {
+func (relay *RelayServer) IsUnstaked(ctx context.Context) (removed bool, err error) {
 	filterOpts := &bind.FilterOpts{
-		Start: 0,
-		End:   nil,
+		Start:   0,
+		End:     nil,
+		Context: ctx,
 	}
 	iter, err := relay.rhub.FilterUnstaked(filterOpts, []common.Address{relay.Address()})
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("Error filtering Unstaked events", "err", err)
 		return
 	}
 	if iter.Event == nil && !iter.Next() {
@@ -352,10 +455,10 @@ func (relay *RelayServer) IsUnstaked() (removed bool, err error) {
 }
 
 //find last TransactionRelayed or RelayAdded
-func (relay *RelayServer) BlockCountSinceLastEvent() (count uint64, err error) {
-	lastBlockHeader, err := relay.Client.HeaderByNumber(context.Background(), nil)
+func (relay *RelayServer) BlockCountSinceLastEvent(ctx context.Context) (count uint64, err error) {
+	lastBlockHeader, err := relay.Client.HeaderByNumber(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("Error getting latest block", "err", err)
 		return
 	}
 	startBlock := uint64(0)
@@ -364,12 +467,13 @@ func (relay *RelayServer) BlockCountSinceLastEvent() (count uint64, err error) {
 		startBlock = lastBlockHeader.Number.Uint64() - relay.RegistrationBlockRate
 	}
 	filterOpts := &bind.FilterOpts{
-		Start: startBlock,
-		End:   &lastBlockNumber,
+		Start:   startBlock,
+		End:     &lastBlockNumber,
+		Context: ctx,
 	}
 	iter, err := relay.rhub.FilterRelayAdded(filterOpts, []common.Address{relay.Address()}, nil)
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("Error filtering RelayAdded events", "err", err)
 		return
 	}
 	// We only care about the last registration event
@@ -382,11 +486,12 @@ func (relay *RelayServer) BlockCountSinceLastEvent() (count uint64, err error) {
 		return 0, fmt.Errorf("Could not receive RelayAdded events for our relay")
 	}
 	blockNumber := iter.Event.Raw.BlockNumber
+	blocksSinceRegistrationGauge.WithLabelValues(relay.relayLabel()).Set(float64(lastBlockNumber - blockNumber))
 
 	//Now find also last TransactionRelayed request, and use the latest of these:
 	iter1, err1 := relay.rhub.FilterTransactionRelayed(filterOpts, []common.Address{relay.Address()}, nil, nil)
 	if err1 != nil {
-		log.Println(err1)
+		relay.Logger.Error("Error filtering TransactionRelayed events", "err", err1)
 		return
 	}
 	// We only care about the last event
@@ -406,14 +511,15 @@ func (relay *RelayServer) GetRegistrationBlockRate() (uint64) {
 	return relay.RegistrationBlockRate
 }
 
-func (relay *RelayServer) IsRemoved() (removed bool, err error) {
+func (relay *RelayServer) IsRemoved(ctx context.Context) (removed bool, err error) {
 	filterOpts := &bind.FilterOpts{
-		Start: 0,
-		End:   nil,
+		Start:   0,
+		End:     nil,
+		Context: ctx,
 	}
 	iter, err := relay.rhub.FilterRelayRemoved(filterOpts, []common.Address{relay.Address()})
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("Error filtering RelayRemoved events", "err", err)
 		return
 	}
 	if iter.Event == nil && !iter.Next() {
@@ -422,30 +528,34 @@ func (relay *RelayServer) IsRemoved() (removed bool, err error) {
 	return true, nil
 }
 
-func (relay *RelayServer) SendBalanceToOwner() (err error) {
-	balance, err := relay.Client.BalanceAt(context.Background(), relay.Address(), nil)
+func (relay *RelayServer) SendBalanceToOwner(ctx context.Context) (err error) {
+	balance, err := relay.Client.BalanceAt(ctx, relay.Address(), nil)
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("SendBalanceToOwner: error getting balance", "err", err)
 		return
 	}
 	if balance.Cmp(big.NewInt(0)) == 0 {
-		log.Println("SendBalanceToOwner: balance is 0")
+		relay.Logger.Info("SendBalanceToOwner: balance is 0")
 		return
 	}
-	log.Println("Sending", balance, "wei to owner address", relay.OwnerAddress.Hex())
+	relay.Logger.Info("Sending balance to owner", "balance", balance, "owner", relay.OwnerAddress)
 
 	var data []byte
 	gasLimit := uint64(21000) // in units
-	gasPrice, err := relay.Client.SuggestGasPrice(context.Background())
+	gasPrice, err := relay.Client.SuggestGasPrice(ctx)
 	if err != nil {
-		log.Println(err)
+		relay.Logger.Error("SendBalanceToOwner: error getting gas price", "err", err)
 		return
 	}
-	cost := big.NewInt(int64(gasPrice.Uint64() * gasLimit))
+	// With dynamic fees the whole fee cap must be covered, although only the effective gas price is paid
+	if fees := relay.DynamicFees(); fees != nil {
+		gasPrice = fees.MaxFeePerGas
+	}
+	cost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
 	value := big.NewInt(0)
 	value.Sub(balance, cost)
 
-	tx, err := relay.sendPlainTransaction(
+	tx, err := relay.sendPlainTransaction(ctx, relay.Logger,
 		fmt.Sprintf("SendBalanceToOwner(to=%s)", relay.OwnerAddress.Hex()),
 		relay.OwnerAddress, value, gasLimit, gasPrice, data,
 	)
@@ -453,90 +563,127 @@ func (relay *RelayServer) SendBalanceToOwner() (err error) {
 	if err != nil {
 		return
 	}
-	return relay.awaitTransactionMined(tx)
+	return relay.awaitTransactionMined(ctx, tx)
 }
 
-func (relay *RelayServer) CreateRelayTransaction(request RelayTransactionRequest) (signedTx *types.Transaction, err error) {
+// CreateRelayTransaction relays the request, logging with its requestID
+func (relay *RelayServer) CreateRelayTransaction(ctx context.Context, request RelayTransactionRequest, requestID string) (signedTx *types.Transaction, err error) {
+	logger := relay.Logger.New("requestId", requestID, "from", request.From, "to", request.To, "paymaster", request.Paymaster)
+
 	// Check that the relayhub is the correct one
 	if bytes.Compare(relay.RelayHubAddress.Bytes(), request.RelayHubAddress.Bytes()) != 0 {
-		err = fmt.Errorf("Wrong hub address.\nRelay server's hub address: %s, request's hub address: %s\n", relay.RelayHubAddress.Hex(), request.RelayHubAddress.Hex())
-		log.Println(err)
+		err = NewRelayError(ErrWrongHub, "Wrong hub address.\nRelay server's hub address: %s, request's hub address: %s\n", relay.RelayHubAddress.Hex(), request.RelayHubAddress.Hex())
+		logger.Warn("Relay request rejected", "err", err)
 		return
 	}
 
 	// Check that the fee is acceptable
 	if !relay.validateFee(request.PercentRelayFee) {
-		err = fmt.Errorf("Unacceptable fee")
-		log.Println(err)
+		err = NewRelayError(ErrFeeTooLow, "Unacceptable fee")
+		logger.Warn("Relay request rejected", "err", err)
 		return
 	}
 
 	// Check that the gasPrice is initialized & acceptable
 	if relay.gasPrice == nil || relay.gasPrice.Cmp(&request.GasPrice) > 0 {
-		err = fmt.Errorf("Unacceptable gasPrice")
-		log.Println(err)
+		err = NewRelayError(ErrGasPriceTooLow, "Unacceptable gasPrice")
+		logger.Warn("Relay request rejected", "err", err)
 		return
 	}
 
-	if request.RelayMaxNonce.Cmp(big.NewInt(int64(lastNonce))) < 0 {
-		err = fmt.Errorf("Unacceptable RelayMaxNonce")
-		log.Println(err, request.RelayMaxNonce)
+	if request.RelayMaxNonce.Cmp(new(big.Int).SetUint64(relay.nonceManager.NextNonce())) < 0 {
+		err = NewRelayError(ErrNonceGap, "Unacceptable RelayMaxNonce")
+		logger.Warn("Relay request rejected", "err", err, "relayMaxNonce", &request.RelayMaxNonce)
 		return
 	}
-	// canRelay returned true, so we can relay the tx
 	relayAddress := relay.Address()
 
 	callOpt := &bind.CallOpts{
 		From:    relayAddress,
 		Pending: false,
+		Context: ctx,
 	}
 
 	// With a transition to sponsor-defined gas limits, the server will need to crunch some numbers
-	sponsor, err := testcontracts.NewTestSponsor(request.Paymaster, relay.Client)
+	paymaster, err := relay.paymaster(ctx, request.Paymaster)
 	if err != nil {
-		log.Println(err)
+		logger.Warn("Error binding paymaster", "err", err)
 		return
 	}
 
-	gasLimits, err := sponsor.GetGasLimits(&bind.CallOpts{From: relayAddress})
+	timer := chainCallTimer("GetGasLimits")
+	gasLimits, err := paymaster.GetGasLimits(callOpt)
+	timer.ObserveDuration()
 	if err != nil {
-		log.Println(err)
+		logger.Error("GetGasLimits() failed", "err", err)
 		return
 	}
 
-	hubOverhead, err := relay.rhub.GetHubOverhead(&bind.CallOpts{From: relayAddress})
+	timer = chainCallTimer("GetHubOverhead")
+	hubOverhead, err := relay.rhub.GetHubOverhead(callOpt)
+	timer.ObserveDuration()
 	if err != nil {
-		log.Println(err)
+		logger.Error("GetHubOverhead() failed", "err", err)
 		return
 	}
-	maxPossibleGas := big.NewInt(0)
-	maxPossibleGas.Add(maxPossibleGas, hubOverhead)
-	maxPossibleGas.Add(maxPossibleGas, gasLimits.AcceptRelayedCallGasLimit)
-	maxPossibleGas.Add(maxPossibleGas, gasLimits.PreRelayedCallGasLimit)
-	maxPossibleGas.Add(maxPossibleGas, &request.GasLimit)
-	maxPossibleGas.Add(maxPossibleGas, gasLimits.PostRelayedCallGasLimit)
 
-	// TODO: well, this sucks! I need to calculate max possible gas using entire msg.data!!!
-	//  In the meantime, these 400'000 extra gas should help :-)
-	maxPossibleGas.Add(maxPossibleGas, big.NewInt(400000))
-	maxPossibleGas.Add(maxPossibleGas, getEncodedFunctionMaxGas(request.EncodedFunction))
-	maxPossibleGas.Add(maxPossibleGas, getEncodedFunctionMaxGas(common.Bytes2Hex(request.ApprovalData)))
+	timer = chainCallTimer("Gtxdatanonzero")
+	gtxDataNonZero, err := relay.gtxDataNonZero(callOpt)
+	timer.ObserveDuration()
+	if err != nil {
+		logger.Error("gtxdatanonzero() failed", "err", err)
+		return
+	}
 
-	gasData := librelay.GSNTypesGasData{
-		GasLimit:        &request.GasLimit,
-		GasPrice:        &request.GasPrice,
-		PctRelayFee:     &request.PercentRelayFee,
-		BaseRelayFee:    &request.BaseRelayFee,
+	relayRequest := librelay.GSNTypesRelayRequest{
+		Target:          request.To,
+		EncodedFunction: common.FromHex(request.EncodedFunction),
+		GasData: librelay.GSNTypesGasData{
+			GasLimit:     &request.GasLimit,
+			GasPrice:     &request.GasPrice,
+			PctRelayFee:  &request.PercentRelayFee,
+			BaseRelayFee: &request.BaseRelayFee,
+		},
+		RelayData: librelay.GSNTypesRelayData{
+			SenderAddress: request.From,
+			SenderNonce:   &request.SenderNonce,
+			RelayAddress:  relayAddress,
+			Paymaster:     request.Paymaster,
+		},
 	}
 
-	maxCharge, err := relay.rhub.CalculateCharge(callOpt, maxPossibleGas, gasData)
+	// The calldata is the only dynamic part of the relayed tx's intrinsic gas, so we price the exact relayCall() input.
+	// The network's per-fork pricing only sets the tx gas limit: RelayHub charges the paymaster its own calldata price.
+	relayCallData, err := relay.rhubABI.Pack("relayCall", relayRequest, request.Signature, request.ApprovalData)
 	if err != nil {
-		log.Println(err)
+		logger.Warn("Error encoding relayCall()", "err", err)
+		return
+	}
+	intrinsicGas, err := relay.intrinsicGas(ctx, relayCallData)
+	if err != nil {
+		logger.Error("Error computing intrinsic gas", "err", err)
+		return
+	}
+
+	requiredGas := new(big.Int).Set(hubOverhead)
+	requiredGas.Add(requiredGas, gasLimits.AcceptRelayedCallGasLimit)
+	requiredGas.Add(requiredGas, gasLimits.PreRelayedCallGasLimit)
+	requiredGas.Add(requiredGas, &request.GasLimit)
+	requiredGas.Add(requiredGas, gasLimits.PostRelayedCallGasLimit)
+	maxPossibleGas := hubCalldataGas(len(relayCallData), gtxDataNonZero)
+	maxPossibleGas.Add(maxPossibleGas, requiredGas)
+
+	timer = chainCallTimer("CalculateCharge")
+	maxCharge, err := relay.rhub.CalculateCharge(callOpt, maxPossibleGas, relayRequest.GasData)
+	timer.ObserveDuration()
+	if err != nil {
+		logger.Error("CalculateCharge() failed", "err", err)
 		return
 	}
 
 	// check canRelay view function to see if we'll get paid for relaying this tx
-	res, err := relay.canRelay(request.From,
+	rejection, err := relay.canRelay(ctx, logger,
+		request.From,
 		request.To,
 		request.Paymaster,
 		maxCharge,
@@ -551,32 +698,26 @@ func (relay *RelayServer) CreateRelayTransaction(request RelayTransactionRequest
 		request.ApprovalData)
 
 	if err != nil {
-		log.Println("canRelay failed in server", err)
+		logger.Error("canRelay failed in server", "err", err)
 		return
 	}
 
-	if res.Uint64() != 0 {
-		errStr := fmt.Sprintln("EncodedFunction:", request.EncodedFunction, "From:", request.From.Hex(), "To:", request.To.Hex(),
-			"GasPrice:", request.GasPrice.String(), "GasLimit:", request.GasLimit.String(), "Nonce:", request.SenderNonce.String(),
-			"BaseFee", request.BaseRelayFee.String(),
-			"PercentFee:", request.PercentRelayFee.String(),
-			"AppData:", hexutil.Encode(request.ApprovalData), "Sig:", hexutil.Encode(request.Signature))
-		errStr = errStr[:len(errStr)-1]
-		err = fmt.Errorf("canRelay() view function returned error code=%d. params:%s", res, errStr)
-		log.Println(err)
+	if rejection != nil {
+		relayErr := NewRelayError(ErrPaymasterRejected, "canRelay() view function returned error code=%d (%s)", rejection.Status, rejection)
+		relayErr.CanRelay = rejection
+		err = relayErr
+		logger.Warn("Relay request rejected", "err", err,
+			"encodedFunction", request.EncodedFunction, "gasPrice", &request.GasPrice, "gasLimit", &request.GasLimit,
+			"senderNonce", &request.SenderNonce, "baseFee", &request.BaseRelayFee, "percentFee", &request.PercentRelayFee,
+			"approvalData", hexutil.Encode(request.ApprovalData), "signature", hexutil.Encode(request.Signature))
 		return
 	}
 
-
-	/*
-	 * Adding the exact gas cost of the encoded function and approval data as they arethe only dynamic parameters in the relayed call.
-	 * While the signature is also byte array, it is checked off chain during canRelay() so any size other than 65 bytes will get reverted on "WrongSignature"
-	*/
-
-
+	timer = chainCallTimer("BalanceOf")
 	sponsorBalance, err := relay.rhub.BalanceOf(callOpt, request.Paymaster)
+	timer.ObserveDuration()
 	if err != nil {
-		log.Println(err)
+		logger.Error("BalanceOf() failed", "err", err)
 		return
 	}
 
@@ -588,33 +729,26 @@ func (relay *RelayServer) CreateRelayTransaction(request RelayTransactionRequest
 	// 4. acceptRelayedCallMaxGas, postRelayedCallMaxGas, preRelayedCallMaxGas - max gas cost of recipient calls acceptRelayedCall(), postRelayedCall() preRelayedCall()
 
 	if sponsorBalance.Cmp(maxCharge) < 0 {
-		err = fmt.Errorf("sponsor balance too low: %d, maxCharge: %d", sponsorBalance, maxCharge)
-		log.Println(err)
+		err = NewRelayError(ErrPaymasterBalanceTooLow, "sponsor balance too low: %d, maxCharge: %d", sponsorBalance, maxCharge)
+		logger.Warn("Relay request rejected", "err", err)
 		return
 	}
 
-	log.Println("Estimated max charge of relayed tx:", maxCharge, "GasLimit of relayed tx:", maxPossibleGas)
+	// RelayHub requires GasReserve to be left on top of the required gas after its own pre-check execution
+	gasLimit := relayCallGasLimit(intrinsicGas, requiredGas.Uint64())
+	logger.Debug("Estimated relayed tx", "maxCharge", maxCharge, "maxPossibleGas", maxPossibleGas, "intrinsicGas", intrinsicGas, "gasLimit", gasLimit)
 
-	signedTx, err = relay.sendDataTransaction(
+	signedTx, err = relay.sendDataTransaction(ctx, logger,
 		fmt.Sprintf("Relay(from=%s, to=%s)", request.From.Hex(), request.To.Hex()),
 		func(auth *bind.TransactOpts) (*types.Transaction, error) {
-			auth.GasLimit = maxPossibleGas.Uint64()
-			auth.GasPrice = &request.GasPrice
-			relayRequest := librelay.GSNTypesRelayRequest{
-					Target:          request.To,
-					EncodedFunction: common.Hex2Bytes(request.EncodedFunction[2:]),
-				GasData:  librelay.GSNTypesGasData{
-					GasLimit:        &request.GasLimit,
-					GasPrice:        &request.GasPrice,
-					PctRelayFee:   &request.PercentRelayFee,
-					BaseRelayFee:  &request.BaseRelayFee,
-				},
-				RelayData: librelay.GSNTypesRelayData{
-					SenderAddress: request.From,
-					SenderNonce:   &request.SenderNonce,
-					RelayAddress:  relay.Address(),
-					Paymaster:     request.Paymaster,
-				},
+			auth.GasLimit = gasLimit
+			if auth.GasFeeCap != nil {
+				// RelayHub requires the effective gas price to be at least the requested one, and that is what we
+				// are paid for: capping both fees at it makes the effective gas price exactly the requested one
+				auth.GasFeeCap = &request.GasPrice
+				auth.GasTipCap = &request.GasPrice
+			} else {
+				auth.GasPrice = &request.GasPrice
 			}
 			return relay.rhub.RelayCall(auth, relayRequest, request.Signature, request.ApprovalData)
 		})
@@ -623,15 +757,7 @@ func (relay *RelayServer) CreateRelayTransaction(request RelayTransactionRequest
 }
 
 func (relay *RelayServer) Address() (relayAddress common.Address) {
-	publicKey := relay.PrivateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		log.Println(
-			"error casting public key to ECDSA")
-		return
-	}
-	relayAddress = crypto.PubkeyToAddress(*publicKeyECDSA)
-	return
+	return relay.Signer.Address()
 }
 
 func (relay *RelayServer) HubAddress() common.Address {
@@ -646,7 +772,25 @@ func (relay *RelayServer) GetPort() string {
 	return relay.Port
 }
 
-func (relay *RelayServer) canRelay(from common.Address,
+// paymaster binds to the given paymaster contract, and checks it is using the same RelayHub as this relay
+func (relay *RelayServer) paymaster(ctx context.Context, address common.Address) (paymaster *librelay.IPaymaster, err error) {
+	paymaster, err = librelay.NewIPaymaster(address, relay.Client)
+	if err != nil {
+		return
+	}
+
+	hubAddress, err := paymaster.GetHubAddr(&bind.CallOpts{From: relay.Address(), Context: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("Could not get paymaster's hub address: %v", err)
+	}
+	if bytes.Compare(relay.RelayHubAddress.Bytes(), hubAddress.Bytes()) != 0 {
+		return nil, NewRelayError(ErrWrongHub, "Wrong paymaster hub address.\nRelay server's hub address: %s, paymaster's hub address: %s\n", relay.RelayHubAddress.Hex(), hubAddress.Hex())
+	}
+	return
+}
+
+func (relay *RelayServer) canRelay(ctx context.Context, logger Logger,
+	from common.Address,
 	to common.Address,
 	paymaster common.Address,
 	maxPossibleCharge *big.Int,
@@ -658,13 +802,14 @@ func (relay *RelayServer) canRelay(from common.Address,
 	gasLimit big.Int,
 	senderNonce big.Int,
 	signature []byte,
-	approvalData []byte) (res *big.Int, err error) {
+	approvalData []byte) (rejection *CanRelayRejection, err error) {
 
 	relayAddress := relay.Address()
 
 	callOpt := &bind.CallOpts{
 		From:    relayAddress,
 		Pending: false,
+		Context: ctx,
 	}
 
 	var result struct {
@@ -688,13 +833,22 @@ func (relay *RelayServer) canRelay(from common.Address,
 			Paymaster:     paymaster,
 		},
 	}
+	timer := chainCallTimer("CanRelay")
 	result, err = relay.rhub.CanRelay(callOpt, relayRequest,maxPossibleCharge, acceptRelayedCallMaxGas, signature, approvalData)
+	timer.ObserveDuration()
 	if err != nil {
-		log.Println(err)
-	} else {
-		res = result.Status
+		logger.Error("CanRelay() failed", "err", err)
+		return
+	}
+	if result.Status.Sign() == 0 {
+		return
 	}
 
+	rejection = decodeCanRelayStatus(result.Status, result.RecipientContext)
+	if rejection.Reason == CanRelayAcceptRelayedCallReverted {
+		rejection.Message = relay.acceptRelayedCallRevertReason(ctx, paymaster, relayRequest, approvalData, maxPossibleCharge)
+	}
+	canRelayRejections.WithLabelValues(rejection.Reason).Inc()
 	return
 }
 
@@ -702,157 +856,212 @@ func (relay *RelayServer) validateFee(relayFee big.Int) bool {
 	return relayFee.Cmp(relay.PercentFee) >= 0
 }
 
-func (relay *RelayServer) sendPlainTransaction(desc string, to common.Address, value *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) (signedTx *types.Transaction, err error) {
-	log.Println(desc, "tx sending")
-	nonceMutex.Lock()
-	defer nonceMutex.Unlock()
+func (relay *RelayServer) sendPlainTransaction(ctx context.Context, logger Logger, desc string, to common.Address, value *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) (signedTx *types.Transaction, err error) {
+	logger.Info("Sending transaction", "desc", desc)
 
-	nonce, err := relay.pollNonce()
+	chainID, err := relay.ChainID(ctx)
 	if err != nil {
-		log.Println(desc, "error polling nonce:", err)
+		logger.Error("Error getting chain id", "desc", desc, "err", err)
 		return
 	}
 
-	tx := types.NewTransaction(nonce, to, value, gasLimit, gasPrice, data)
-
-	chainID, err := relay.ChainID()
+	nonce, err := relay.nonceManager.Reserve(ctx, relay.DevMode)
 	if err != nil {
-		log.Println(desc, "error getting chain id:", err)
+		logger.Error("Error reserving nonce", "desc", desc, "err", err)
 		return
 	}
 
-	signedTx, err = types.SignTx(tx, types.NewEIP155Signer(chainID), relay.PrivateKey)
+	tx := relay.newPlainTransaction(chainID, nonce, to, value, gasLimit, gasPrice, data)
+	signedTx, err = relay.signTransaction(ctx, tx, chainID)
 	if err != nil {
-		log.Println(desc, "error signing tx:", err)
+		relay.nonceManager.Release(nonce)
+		logger.Error("Error signing transaction", "desc", desc, "nonce", nonce, "err", err)
 		return
 	}
 
-	err = relay.Client.SendTransaction(context.Background(), signedTx)
-	if err != nil {
-		log.Println(desc, "error sending tx:", err)
+	stored, err := relay.broadcastTransaction(ctx, logger, desc, signedTx)
+	if !stored {
+		relay.nonceManager.Release(nonce)
 		return
 	}
-
-	log.Println(desc, "tx sent:", signedTx.Hash().Hex())
-	lastNonce++
-
-	err = relay.TxStore.SaveTransaction(signedTx)
+	relay.nonceManager.Commit(nonce)
 	if err != nil {
-		log.Println(desc, "error saving tx:", err)
 		return
 	}
 
+	logger.Info("Transaction sent", "desc", desc, "nonce", nonce, "txHash", signedTx.Hash())
 	return
 }
 
-func (relay *RelayServer) sendDataTransaction(desc string, f func(*bind.TransactOpts) (*types.Transaction, error)) (tx *types.Transaction, err error) {
-	log.Println(desc, "tx sending")
-	nonceMutex.Lock()
-	defer nonceMutex.Unlock()
-	auth := bind.NewKeyedTransactor(relay.PrivateKey)
-	nonce, err := relay.pollNonce()
+// signTransaction signs tx with the relay's Signer, unless the SigningGuard refuses to
+func (relay *RelayServer) signTransaction(ctx context.Context, tx *types.Transaction, chainID *big.Int) (signedTx *types.Transaction, err error) {
+	return relay.SigningGuard.Sign(tx, func(tx *types.Transaction) (*types.Transaction, error) {
+		return relay.Signer.SignTx(ctx, tx, chainID)
+	})
+}
+
+// newPlainTransaction returns an unsigned transaction paying the current dynamic fees, or gasPrice before London
+func (relay *RelayServer) newPlainTransaction(chainID *big.Int, nonce uint64, to common.Address, value *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte) *types.Transaction {
+	if fees := relay.DynamicFees(); fees != nil {
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: fees.MaxPriorityFeePerGas,
+			GasFeeCap: fees.MaxFeePerGas,
+			Gas:       gasLimit,
+			To:        &to,
+			Value:     value,
+			Data:      data,
+		})
+	}
+	return types.NewTransaction(nonce, to, value, gasLimit, gasPrice, data)
+}
+
+func (relay *RelayServer) sendDataTransaction(ctx context.Context, logger Logger, desc string, f func(*bind.TransactOpts) (*types.Transaction, error)) (tx *types.Transaction, err error) {
+	logger.Info("Sending transaction", "desc", desc)
+	chainID, err := relay.ChainID(ctx)
 	if err != nil {
-		log.Println(desc, "error polling nonce:", err)
+		logger.Error("Error getting chain id", "desc", desc, "err", err)
 		return
 	}
-	auth.Nonce = big.NewInt(int64(nonce))
+	auth := &bind.TransactOpts{
+		From:    relay.Address(),
+		Context: ctx,
+		NoSend:  true, // sent by broadcastTransaction once stored
+		Signer: func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != relay.Address() {
+				return nil, bind.ErrNotAuthorized
+			}
+			return relay.signTransaction(ctx, tx, chainID)
+		},
+	}
+	if fees := relay.DynamicFees(); fees != nil {
+		auth.GasFeeCap = fees.MaxFeePerGas
+		auth.GasTipCap = fees.MaxPriorityFeePerGas
+	}
+	nonce, err := relay.nonceManager.Reserve(ctx, relay.DevMode)
+	if err != nil {
+		logger.Error("Error reserving nonce", "desc", desc, "err", err)
+		return
+	}
+	auth.Nonce = new(big.Int).SetUint64(nonce)
 	tx, err = f(auth)
 	if err != nil {
-		log.Println(desc, "error sending tx:", err)
+		relay.nonceManager.Release(nonce)
+		logger.Error("Error creating transaction", "desc", desc, "nonce", nonce, "err", err)
 		return
 	}
-
-	log.Printf("%v tx sent: %v (%v)\n", desc, tx.Hash().Hex(), tx.Nonce())
-	lastNonce++
-
-	// TODO: Monitor for tx mined
-	err = relay.TxStore.SaveTransaction(tx)
+	stored, err := relay.broadcastTransaction(ctx, logger, desc, tx)
+	if !stored {
+		relay.nonceManager.Release(nonce)
+		return
+	}
+	relay.nonceManager.Commit(nonce)
 	if err != nil {
-		log.Println(desc, "error saving tx:", err)
 		return
 	}
 
+	// TODO: Monitor for tx mined
+	logger.Info("Transaction sent", "desc", desc, "nonce", tx.Nonce(), "txHash", tx.Hash())
 	return
 }
 
-const maxGasPrice = 100e9
-const retryGasPricePercentageIncrease = 20
-
-func (relay *RelayServer) resendTransaction(tx *types.Transaction) (signedTx *types.Transaction, err error) {
-	// Calculate new gas price as a % increase over the previous one
-	newGasPrice := big.NewInt(100 + retryGasPricePercentageIncrease)
-	newGasPrice.Mul(newGasPrice, tx.GasPrice())
-	newGasPrice.Div(newGasPrice, big.NewInt(100))
+// replacementTransaction returns a copy of a pending transaction, with fees bumped according to the ResendPolicy
+func (relay *RelayServer) replacementTransaction(ctx context.Context, timedTx *txstore.TimestampedTransaction, chainID *big.Int) (newTx *types.Transaction, err error) {
+	tx := timedTx.Transaction
+	original := timedTx.Attempts[0]
+	attempt := len(timedTx.Attempts)
 
-	// Sanity check to ensure we are not burning all our balance in gas fees
-	if newGasPrice.Cmp(big.NewInt(maxGasPrice)) > 0 {
-		log.Println("Capping gas price to max value of", maxGasPrice)
-		newGasPrice.SetUint64(maxGasPrice)
-	}
+	// Resend transaction with exactly the same values except for gas price
+	if tx.Type() == types.DynamicFeeTxType {
+		// Both fee caps must be bumped for nodes to accept the replacement
+		var currentFeeCap, currentTipCap *big.Int
+		if fees := relay.DynamicFees(); fees != nil {
+			currentFeeCap, currentTipCap = fees.MaxFeePerGas, fees.MaxPriorityFeePerGas
+		}
+		originalTipCap := original.GasTipCap
+		if originalTipCap == nil {
+			originalTipCap = tx.GasTipCap()
+		}
+		newFeeCap, err := relay.ResendPolicy.NextFee(original.GasPrice, tx.GasFeeCap(), currentFeeCap, attempt)
+		if err != nil {
+			return nil, err
+		}
+		newTipCap, err := relay.ResendPolicy.NextFee(originalTipCap, tx.GasTipCap(), currentTipCap, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if newTipCap.Cmp(newFeeCap) > 0 {
+			newTipCap = newFeeCap
+		}
 
-	// Grab chain ID
-	chainID, err := relay.ChainID()
-	if err != nil {
-		return
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     tx.Nonce(),
+			GasTipCap: newTipCap,
+			GasFeeCap: newFeeCap,
+			Gas:       tx.Gas(),
+			To:        tx.To(),
+			Value:     tx.Value(),
+			Data:      tx.Data(),
+		}), nil
 	}
 
-	// Resend transaction with exactly the same values except for gas price
-	newTx := types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), newGasPrice, tx.Data())
-	signedTx, err = types.SignTx(newTx, types.NewEIP155Signer(chainID), relay.PrivateKey)
+	newGasPrice, err := relay.ResendPolicy.NextFee(original.GasPrice, tx.GasPrice(), relay.gasPrice, attempt)
 	if err != nil {
-		log.Println("ResendTransaction: error signing tx", err)
 		return
 	}
+	return types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), newGasPrice, tx.Data()), nil
+}
 
-	err = relay.Client.SendTransaction(context.Background(), signedTx)
+func (relay *RelayServer) resendTransaction(ctx context.Context, newTx *types.Transaction, chainID *big.Int) (signedTx *types.Transaction, err error) {
+	signedTx, err = relay.signTransaction(ctx, newTx, chainID)
 	if err != nil {
-		log.Println("ResendTransaction: error sending tx", err)
+		relay.Logger.Error("ResendTransaction: error signing transaction", "nonce", newTx.Nonce(), "err", err)
 		return
 	}
 
+	// Stored before it is sent, so a crash in between does not lose track of the attempt the node may have received
+	err = relay.broadcastReplacement(ctx, relay.Logger, "ResendTransaction", signedTx)
 	return
 }
 
-func (relay *RelayServer) awaitTransactionMined(tx *types.Transaction) (err error) {
-	start := time.Now()
+// awaitTransactionMined polls for the receipt of tx for up to TxReceiptTimeout, or until ctx is done
+func (relay *RelayServer) awaitTransactionMined(ctx context.Context, tx *types.Transaction) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, TxReceiptTimeout)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
 	var receipt *types.Receipt
-	for ; (receipt == nil || err != nil) && time.Since(start) < TxReceiptTimeout; receipt, err = relay.Client.TransactionReceipt(context.Background(), tx.Hash()) {
-		time.Sleep(500 * time.Millisecond)
-	}
-	if err != nil {
-		log.Println("Could not get tx receipt", err)
-		return
+	for receipt == nil {
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			relay.Logger.Error("Could not get transaction receipt", "txHash", tx.Hash(), "err", err)
+			return
+		case <-ticker.C:
+		}
+		receipt, err = relay.Client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			receipt = nil
+		}
 	}
 	if receipt.Status != 1 {
-		log.Println("tx failed: tx receipt status", receipt.Status)
+		relay.Logger.Error("Transaction failed", "txHash", tx.Hash(), "status", receipt.Status)
 		return
 	}
 
 	return nil
 }
 
-func (relay *RelayServer) pollNonce() (nonce uint64, err error) {
-	ctx := context.Background()
-	fromAddress := relay.Address()
-	nonce, err = relay.Client.PendingNonceAt(ctx, fromAddress)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	// Always overwrite nonce cache if on dev mode
-	if relay.DevMode || lastNonce <= nonce {
-		lastNonce = nonce
-	} else {
-		nonce = lastNonce
-	}
-	return
-}
-
 const confirmationsNeeded = 12
-const pendingTransactionTimeout = 5 * 60 // 5 minutes
 
-func (relay *RelayServer) UpdateUnconfirmedTransactions() (newTx *types.Transaction, err error) {
+// UpdateUnconfirmedTransactions forgets confirmed transactions, and replaces all the stuck ones (pending for longer
+// than the ResendPolicy's timeout) in nonce order. Returns the replacements sent.
+func (relay *RelayServer) UpdateUnconfirmedTransactions(ctx context.Context) (newTxs []*types.Transaction, err error) {
 	if relay.DevMode {
 		return nil, nil
 	}
@@ -860,7 +1069,7 @@ func (relay *RelayServer) UpdateUnconfirmedTransactions() (newTx *types.Transact
 	// Load unconfirmed transactions from store, and bail if there are none
 	tx, err := relay.TxStore.GetFirstTransaction()
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error retrieving first transaction from local store", err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving first transaction from local store", "err", err)
 		return
 	}
 
@@ -869,10 +1078,9 @@ func (relay *RelayServer) UpdateUnconfirmedTransactions() (newTx *types.Transact
 	}
 
 	// Get latest block number in the network
-	ctx := context.Background()
 	latest, err := relay.Client.HeaderByNumber(ctx, nil)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error retrieving last block number", err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving last block number", "err", err)
 		return
 	}
 
@@ -881,89 +1089,193 @@ func (relay *RelayServer) UpdateUnconfirmedTransactions() (newTx *types.Transact
 	confirmedBlock.Sub(latest.Number, big.NewInt(confirmationsNeeded))
 	nonce, err := relay.Client.NonceAt(ctx, relay.Address(), &confirmedBlock)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error retrieving nonce for", relay.Address().Hex(), "on block", confirmedBlock.Uint64(), err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving nonce", "block", &confirmedBlock, "err", err)
 		return
 	}
 
 	// Clear out all confirmed transactions (ie txs with nonce less than the account nonce at confirmationsNeeded blocks ago)
 	err = relay.TxStore.RemoveTransactionsLessThanNonce(nonce)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error deleting confirmed transactions", err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error deleting confirmed transactions", "err", err)
 		return
 	}
+	relay.updatePendingTransactionsGauge()
 
-	// Get first unconfirmed transaction
-	tx, err = relay.TxStore.GetFirstTransaction()
+	// Get unconfirmed transactions
+	txs, err := relay.TxStore.ListTransactions()
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error retrieving unconfirmed transaction from local store", err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving unconfirmed transactions from local store", "err", err)
 		return
 	}
 
-	if tx == nil {
+	if len(txs) == 0 {
 		return
 	}
 
-	// Check if the tx was mined by comparing its nonce against the latest one
-	nonce, err = relay.Client.NonceAt(ctx, relay.Address(), nil)
+	// Transactions queued after a missing nonce are never mined, however often they are resent
+	gaps, fillers, err := relay.FillNonceGaps(ctx)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error retrieving nonce for", relay.Address().Hex(), err)
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error filling nonce gaps", "gaps", gaps, "err", err)
 		return
 	}
+	if len(gaps) > 0 {
+		relay.Logger.Warn("UpdateUnconfirmedTransactions: filled nonce gaps", "gaps", gaps, "txHashes", fillers)
+	}
 
-	if tx.Nonce() < nonce {
-		log.Println("UpdateUnconfirmedTransactions: awaiting confirmations for next mined transaction", nonce, tx.Nonce(), tx.Hash().Hex())
-		return nil, nil
+	// Mined transactions are told apart by comparing their nonces against the latest one
+	nonce, err = relay.Client.NonceAt(ctx, relay.Address(), nil)
+	if err != nil {
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving nonce", "err", err)
+		return
 	}
 
-	// If the tx is still pending, check how long ago we sent it, and resend it if needed
-	if relay.clock.Now().Unix()-tx.Timestamp < pendingTransactionTimeout {
-		log.Println("UpdateUnconfirmedTransactions: awaiting transaction to be mined", nonce, tx.Hash().Hex())
+	chainID, err := relay.ChainID(ctx)
+	if err != nil {
+		return
+	}
+	balance, err := relay.Balance(ctx)
+	if err != nil {
+		relay.Logger.Error("UpdateUnconfirmedTransactions: error retrieving balance", "err", err)
 		return
 	}
+	maxTotalCost := relay.ResendPolicy.MaxTotalCost(balance)
+	totalCost := big.NewInt(0)
+
+	for _, tx := range txs {
+		// Mined transactions only await confirmations, while the later ones may still be stuck
+		if tx.Nonce() < nonce {
+			relay.Logger.Debug("UpdateUnconfirmedTransactions: awaiting confirmations for mined transaction", "accountNonce", nonce, "nonce", tx.Nonce(), "txHash", tx.Hash())
+			continue
+		}
+
+		// If the tx is still pending, check how long ago we sent it, and resend it if needed
+		if relay.clock.Since(time.Unix(tx.Timestamp, 0)) < relay.ResendPolicy.Timeout {
+			relay.Logger.Debug("UpdateUnconfirmedTransactions: awaiting transaction to be mined", "nonce", tx.Nonce(), "txHash", tx.Hash())
+			continue
+		}
+
+		newTx, err := relay.replacementTransaction(ctx, tx, chainID)
+		if err != nil {
+			relay.Logger.Error("UpdateUnconfirmedTransactions: error bumping fees of transaction", "nonce", tx.Nonce(), "txHash", tx.Hash(), "err", err)
+			return newTxs, err
+		}
+
+		// Sanity check to ensure we are not burning all our balance in gas fees
+		totalCost.Add(totalCost, newTx.Cost())
+		if totalCost.Cmp(maxTotalCost) > 0 {
+			err = fmt.Errorf("resending transaction %d would raise the total cost of replacements to %s, over the %d%% of the balance %s allowed",
+				tx.Nonce(), totalCost, relay.ResendPolicy.MaxTotalCostPercent, balance)
+			relay.Logger.Error("UpdateUnconfirmedTransactions: resend cost too high", "nonce", tx.Nonce(), "err", err)
+			return newTxs, err
+		}
+
+		signedTx, err := relay.resendTransaction(ctx, newTx, chainID)
+		if err != nil {
+			relay.Logger.Error("UpdateUnconfirmedTransactions: error resending transaction", "nonce", tx.Nonce(), "txHash", tx.Hash(), "err", err)
+			return newTxs, err
+		}
+		relay.Logger.Info("UpdateUnconfirmedTransactions: resent transaction", "nonce", tx.Nonce(), "txHash", tx.Hash(), "newTxHash", signedTx.Hash())
+		newTxs = append(newTxs, signedTx)
+		resentTransactions.WithLabelValues(relay.relayLabel()).Inc()
+	}
 
-	newtx, err := relay.resendTransaction(tx.Transaction)
+	return newTxs, nil
+}
+
+func (relay *RelayServer) Close() (err error) {
+	err = relay.TxStore.Close()
+	if guardErr := relay.SigningGuard.Close(); err == nil {
+		err = guardErr
+	}
+	if remote, ok := relay.Signer.(*RemoteSigner); ok {
+		remote.Close()
+	}
+	return
+}
+
+// intrinsicGas returns the gas charged by the network for a transaction to RelayHub with the given calldata,
+// before any code is executed, using the calldata pricing of the fork active on the latest block
+func (relay *RelayServer) intrinsicGas(ctx context.Context, data []byte) (gas uint64, err error) {
+	latest, err := relay.Client.HeaderByNumber(ctx, nil)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error resending transaction", tx.Hash().Hex(), err)
-		return nil, err
+		return
 	}
-	log.Println("UpdateUnconfirmedTransactions: resent transaction", tx.Nonce(), tx.Hash().Hex(), "as", newtx.Hash().Hex())
+	isEIP2028, err := relay.isEIP2028(ctx, latest.Number)
+	if err != nil {
+		return
+	}
+	nonZeroGas := params.TxDataNonZeroGasFrontier
+	if isEIP2028 {
+		nonZeroGas = params.TxDataNonZeroGasEIP2028
+	}
+	return params.TxGas + calldataGas(data, nonZeroGas), nil
+}
 
-	// TODO: Increase timetamp of subsequent txs?
-	err = relay.TxStore.UpdateTransactionByNonce(newtx)
+// Chain configs of the public networks known to go-ethereum, keyed by network ID
+var knownChainConfigs = map[uint64]*params.ChainConfig{
+	1: params.MainnetChainConfig,
+	3: params.RopstenChainConfig,
+	4: params.RinkebyChainConfig,
+	5: params.GoerliChainConfig,
+}
+
+// isEIP2028 returns whether non-zero calldata bytes are priced as per EIP-2028 (Istanbul) at the given block.
+// Networks we have no config for (e.g. ganache or private chains) are assumed to run Istanbul rules, like the JS client
+func (relay *RelayServer) isEIP2028(ctx context.Context, blockNumber *big.Int) (bool, error) {
+	chainID, err := relay.ChainID(ctx)
 	if err != nil {
-		log.Println("UpdateUnconfirmedTransactions: error updating transaction in local store", newtx.Hash().Hex(), err)
+		return false, err
+	}
+	config, ok := knownChainConfigs[chainID.Uint64()]
+	if !ok {
+		return true, nil
+	}
+	return config.IsIstanbul(blockNumber), nil
+}
+
+// gtxDataNonZero returns the price RelayHub charges per calldata byte, set when it was deployed
+func (relay *RelayServer) gtxDataNonZero(callOpt *bind.CallOpts) (*big.Int, error) {
+	var out []interface{}
+	if err := relay.rhubGas.Call(callOpt, &out, "gtxdatanonzero"); err != nil {
 		return nil, err
 	}
+	return *abi.ConvertType(out[0], new(*big.Int)).(**big.Int), nil
+}
 
-	return newtx, nil
+// hubCalldataGas mirrors RelayHub's calldatagascost(): the gas it charges paymasters for a relayCall() whose calldata
+// is calldataLength bytes long, pricing zero and non-zero bytes alike at gtxDataNonZero
+func hubCalldataGas(calldataLength int, gtxDataNonZero *big.Int) *big.Int {
+	gas := new(big.Int).Mul(big.NewInt(int64(calldataLength)), gtxDataNonZero)
+	return gas.Add(gas, new(big.Int).SetUint64(params.TxGas))
 }
 
-func (relay *RelayServer) Close() (err error) {
-	return relay.TxStore.Close()
+// relayCallGasLimit returns the gas limit of a relayCall() with the given intrinsic gas, leaving RelayHub the gas it
+// requires when it checks gasleft(): GasReserve plus the required gas (its overhead, the paymaster's limits and the
+// request's gas limit), on top of what it spent until then
+func relayCallGasLimit(intrinsicGas uint64, requiredGas uint64) uint64 {
+	return intrinsicGas + RelayCallPreCheckGas + GasReserve + requiredGas
 }
 
 /**
- * It is going to be too expensive to run the estimate on-chain, so in the meantime
- * the encoded function cost calculation is going to be a little... Approximate
+ * @return Gas cost of the calldata bytes, not including the base transaction cost
+ * As per the yellowpaper, each zero byte costs 4, and each non-zero byte costs nonZeroGas (68, or 16 after Istanbul)
  */
-func getEncodedFunctionMaxGas(encodedFunction string) (*big.Int) {
-	return big.NewInt(int64(len(encodedFunction) * 68))
+func calldataGas(data []byte, nonZeroGas uint64) uint64 {
+	gas := uint64(0)
+	for _, b := range data {
+		if b == 0 {
+			gas += params.TxDataZeroGas
+		} else {
+			gas += nonZeroGas
+		}
+	}
+	return gas
 }
+
 /**
  * @return Gas cost of encoded function as parameter in relayedCall
  * As per the yellowpaper, each non-zero byte costs 68 and zero byte costs 4
  */
 func getEncodedFunctionGas(encodedFunction string) (*big.Int) {
-	if strings.HasPrefix(encodedFunction, "0x") {
-		encodedFunction = encodedFunction[2:]
-	}
-	gasLimitSlack := int64(0)
-	for i := 0; i < len(encodedFunction); i += 2 {
-		if encodedFunction[i:i+2] == "00" {
-			gasLimitSlack += 4
-		} else {
-			gasLimitSlack += 68
-		}
-	}
-	return big.NewInt(gasLimitSlack)
+	return new(big.Int).SetUint64(calldataGas(common.FromHex(encodedFunction), params.TxDataNonZeroGasFrontier))
 }
@@ -0,0 +1,28 @@
+package librelay
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Value of RelayHub's private GTRANSACTION
+const hubTransaction = 21000
+
+func TestHubCalldataGas(t *testing.T) {
+	// RelayHub's calldatagascost(): GTRANSACTION + msg.data.length * gtxdatanonzero
+	data := make([]byte, 100)
+	data[0], data[1] = 0x12, 0x34
+	for _, gtxDataNonZero := range []int64{16, 68} {
+		expected := big.NewInt(hubTransaction + int64(len(data))*gtxDataNonZero)
+		gas := hubCalldataGas(len(data), big.NewInt(gtxDataNonZero))
+		if gas.Cmp(expected) != 0 {
+			t.Errorf("Expected %s calldata gas at %d per byte, got %s", expected, gtxDataNonZero, gas)
+		}
+		// Zero bytes cost the hub as much as non-zero ones, so the network's intrinsic gas is no estimate of the charge
+		if intrinsicGas := params.TxGas + calldataGas(data, uint64(gtxDataNonZero)); gas.Uint64() <= intrinsicGas {
+			t.Errorf("Expected the hub to charge more than the intrinsic gas %d of mostly zero calldata, got %s", intrinsicGas, gas)
+		}
+	}
+}
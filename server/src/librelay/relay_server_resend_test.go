@@ -0,0 +1,93 @@
+package librelay
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"openeth.dev/librelay/test"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// unconfirmedClient is a reconcileClient whose account nonce lags confirmationsNeeded blocks behind the latest one
+type unconfirmedClient struct {
+	*reconcileClient
+	confirmedNonce uint64
+}
+
+func (client *unconfirmedClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	if blockNumber != nil {
+		return client.confirmedNonce, nil
+	}
+	return client.accountNonce, nil
+}
+
+func (client *unconfirmedClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(100)}, nil
+}
+
+func (client *unconfirmedClient) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return big.NewInt(1e18), nil
+}
+
+func newUnconfirmedRelay(t *testing.T, client *unconfirmedClient) *RelayServer {
+	relay := newBroadcastRelay(t, client.broadcastClient)
+	relay.Client = client
+	relay.nonceManager = NewNonceManager(relay.Address(), client, relay.TxStore, relay.Logger)
+	relay.gasPrice = big.NewInt(1)
+	relay.clock = fakeclock.NewFakeClock(time.Now().Add(time.Hour))
+	var err error
+	relay.ResendPolicy, err = NewResendPolicy(DefaultResendPolicyConfig())
+	test.ErrFail(err, t)
+	return relay
+}
+
+func TestUpdateUnconfirmedTransactionsResendsStuckAfterMined(t *testing.T) {
+	// Tx 3 was mined but is not confirmed yet, while tx 4 is stuck
+	mined, stuck := newNonceTx(3), newNonceTx(4)
+	client := &unconfirmedClient{
+		reconcileClient: &reconcileClient{broadcastClient: &broadcastClient{accountNonce: 4}, pendingNonce: 4},
+		confirmedNonce:  3,
+	}
+	relay := newUnconfirmedRelay(t, client)
+	test.ErrFail(relay.TxStore.SaveTransaction(mined), t)
+	test.ErrFail(relay.TxStore.SaveTransaction(stuck), t)
+
+	newTxs, err := relay.UpdateUnconfirmedTransactions(context.Background())
+	test.ErrFail(err, t)
+	if len(newTxs) != 1 || newTxs[0].Nonce() != 4 || len(client.sent) != 1 || client.sent[0].Hash() != newTxs[0].Hash() {
+		t.Fatalf("Expected only tx 4 to be resent, got %v and sent %v", newTxs, client.sent)
+	}
+	if newTxs[0].GasPrice().Cmp(stuck.GasPrice()) <= 0 {
+		t.Errorf("Expected the replacement of tx 4 to pay more than %s, got %s", stuck.GasPrice(), newTxs[0].GasPrice())
+	}
+	stored, err := relay.TxStore.GetTransactionByHash(newTxs[0].Hash())
+	test.ErrFail(err, t)
+	if stored == nil {
+		t.Errorf("Expected the replacement of tx 4 to be stored")
+	}
+}
+
+func TestResendTransactionStoresReplacementBeforeSending(t *testing.T) {
+	stuck := newNonceTx(4)
+	client := &unconfirmedClient{
+		reconcileClient: &reconcileClient{broadcastClient: &broadcastClient{accountNonce: 4, sendErr: errors.New("unreachable")}, pendingNonce: 5},
+		confirmedNonce:  4,
+	}
+	relay := newUnconfirmedRelay(t, client)
+	test.ErrFail(relay.TxStore.SaveTransaction(stuck), t)
+
+	if _, err := relay.UpdateUnconfirmedTransactions(context.Background()); err == nil {
+		t.Fatalf("Expected send error")
+	}
+	// The node may have received the replacement, so it is kept to be resent
+	stored, err := relay.TxStore.GetFirstTransaction()
+	test.ErrFail(err, t)
+	if stored.Hash() == stuck.Hash() || !stored.Unsent || len(stored.Attempts) != 2 {
+		t.Errorf("Expected the replacement of tx 4 to be stored as unsent, got %v", stored)
+	}
+}
@@ -87,7 +87,7 @@ func (relay *TestServer) Stake(ownerKey *ecdsa.PrivateKey, stakeAmount *big.Int,
 	if err != nil {
 		return err
 	}
-	return relay.awaitTransactionMined(tx)
+	return relay.awaitTransactionMined(context.Background(), tx)
 }
 
 func (relay *TestServer) sendStakeTransaction(ownerKey *ecdsa.PrivateKey, stakeAmount *big.Int, unstakeDelay *big.Int) (tx *types.Transaction, err error) {
@@ -107,7 +107,7 @@ func (relay *TestServer) Unstake(ownerKey *ecdsa.PrivateKey, stakeAmount *big.In
 	if err != nil {
 		return err
 	}
-	return relay.awaitTransactionMined(tx)
+	return relay.awaitTransactionMined(context.Background(), tx)
 
 }
 
@@ -179,8 +179,8 @@ func NewRelay(relayHubAddress common.Address) {
 	relay.RelayServer, err = NewRelayServer(
 		common.Address{}, baseFee, fee, url, port,
 		relayHubAddress, defaultGasPrice,
-		gasPricePercent, relayKey1, registrationBlockRate,
-		ethereumNodeURL, client, txStore, clk, devMode)
+		gasPricePercent, NewLocalSigner(relayKey1), registrationBlockRate,
+		ethereumNodeURL, client, txStore, txstore.NewMemorySignedNonceStore(), clk, devMode)
 	if err != nil {
 		log.Fatalln("Relay was not created", err)
 	}
@@ -188,6 +188,9 @@ func NewRelay(relayHubAddress common.Address) {
 }
 
 func TestMain(m *testing.M) {
+	if err := SetupLogging(os.Stderr, LogFormatTerminal, "debug"); err != nil {
+		log.Fatalln(err)
+	}
 	InitTestClient(ethereumNodeURL)
 	parsed, err := abi.JSON(strings.NewReader(librelay.IRelayHubABI))
 	if err != nil {
@@ -257,7 +260,7 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Could not 'sendStakeTransaction': %v", err)
 	}
 	client.Commit()
-	err = relay.awaitTransactionMined(tx)
+	err = relay.awaitTransactionMined(context.Background(), tx)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -289,7 +292,7 @@ func TestMain(m *testing.M) {
 
 func TestRefreshGasPrice(t *testing.T) {
 	gasPriceBefore := relay.GasPrice()
-	test.ErrFail(relay.RefreshGasPrice(), t)
+	test.ErrFail(relay.RefreshGasPrice(context.Background()), t)
 	gasPriceAfter := relay.GasPrice()
 	if gasPriceBefore.Cmp(big.NewInt(0)) != 0 {
 		t.Error()
@@ -301,7 +304,7 @@ func TestRefreshGasPrice(t *testing.T) {
 }
 
 func TestRegisterRelay(t *testing.T) {
-	staked, err := relay.IsStaked()
+	staked, err := relay.IsStaked(context.Background())
 	if !staked {
 		t.Error("Relay is not staked")
 	}
@@ -309,14 +312,14 @@ func TestRegisterRelay(t *testing.T) {
 	// TODO: Watch out for FLICKERING: attempt to AdjustTime ahead of machine clock will have no effect at all
 	err = client.AdjustTime(50)
 	client.Commit()
-	tx, err := relay.sendRegisterTransaction()
+	tx, err := relay.sendRegisterTransaction(context.Background())
 	test.ErrFail(err, t)
 	if err != nil {
 		fmt.Println("ERROR", err)
 	}
 	client.Commit()
-	test.ErrFail(relay.awaitTransactionMined(tx), t)
-	count, err := relay.BlockCountSinceLastEvent()
+	test.ErrFail(relay.awaitTransactionMined(context.Background(), tx), t)
+	count, err := relay.BlockCountSinceLastEvent(context.Background())
 	if err != nil {
 		fmt.Println("ERROR", err)
 	}
@@ -325,6 +328,13 @@ func TestRegisterRelay(t *testing.T) {
 	}
 }
 
+func TestHealth(t *testing.T) {
+	health := relay.Health(context.Background())
+	if !health.Healthy || health.EthereumNode != "ok" || health.Database != "ok" {
+		t.Error("Relay is unhealthy", health)
+	}
+}
+
 func printSignature(txb string, baseFee int64, txFee int64, gasPrice int64, gasLimit int64, relayMaxNonce int64, senderNonce int64) {
 	fmt.Println("ganache-cli -d")
 	fmt.Println("npx truffle console --network development")
@@ -339,7 +349,7 @@ func printSignature(txb string, baseFee int64, txFee int64, gasPrice int64, gasL
 }
 
 func newRelayTransactionRequest(t *testing.T, senderNonce int64, signature string) (request RelayTransactionRequest) {
-	test.ErrFail(relay.RefreshGasPrice(), t)
+	test.ErrFail(relay.RefreshGasPrice(context.Background()), t)
 	addressGasless := crypto.PubkeyToAddress(gaslessKey2.PublicKey)
 	txb := "0x2ac0df260000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000b68656c6c6f20776f726c64000000000000000000000000000000000000000000"
 	baseFee := int64(300)
@@ -374,6 +384,9 @@ func newRelayTransactionRequest(t *testing.T, senderNonce int64, signature strin
 func assertTransactionRelayed(t *testing.T, txHash common.Hash) (receipt *types.Receipt) {
 	receipt, err := client.TransactionReceipt(context.Background(), txHash)
 	test.ErrFailWithDesc(err, t, fmt.Sprint("Fetching transaction receipt for hash ", txHash.Hex()))
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("Relayed transaction %s reverted after using %d gas", txHash.Hex(), receipt.GasUsed)
+	}
 	logsLen := len(receipt.Logs)
 	expectedLogs := 4
 	if logsLen != expectedLogs {
@@ -403,16 +416,16 @@ func assertRelayNonce(t *testing.T, expected uint64) {
 }
 
 func assertNoTransactionResent(t *testing.T, relay *RelayServer) {
-	noTx, err := relay.UpdateUnconfirmedTransactions()
+	noTxs, err := relay.UpdateUnconfirmedTransactions(context.Background())
 	test.ErrFailWithDesc(err, t, "Updating unconfirmed transactions")
-	if noTx != nil {
+	for _, noTx := range noTxs {
 		t.Errorf("Expected no tx to be resent upon updating unconfirmed txs, but %v with nonce %v was resent", noTx.Hash().Hex(), noTx.Nonce())
 	}
 }
 
 func TestCreateRelayTransaction(t *testing.T) {
 	request := newRelayTransactionRequest(t, 0, "0xc2f3ccc4a624ca99cbd7928503f33347a3dcaaf624d8640610c0193e7b3ae868409d84ad4a97c8f01c0bd8f9b65bd81a9efd44b865ad75df13bdfb512d809b911b")
-	signedTx, err := relay.CreateRelayTransaction(request)
+	signedTx, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction")
 	client.Commit()
 	assertTransactionRelayed(t, signedTx.Hash())
@@ -422,7 +435,7 @@ func TestCreateRelayTransaction(t *testing.T) {
 func TestBlockCountShouldCheckAllEvents(t *testing.T) {
     //make sure that not only RelayAdded, but also TransactionRelayed counts for
     // "last relay message"
-    count, err := relay.BlockCountSinceLastEvent()
+    count, err := relay.BlockCountSinceLastEvent(context.Background())
     if err != nil {
         fmt.Println("ERROR", err)
     }
@@ -438,7 +451,7 @@ func TestResendRelayTransaction(t *testing.T) {
 	// Send a transaction via the relay, but then revert to a previous snapshot
 	snapshotID, err := client.Snapshot()
 	test.ErrFailWithDesc(err, t, "Creating snapshot")
-	signedTx, err := relay.CreateRelayTransaction(request)
+	signedTx, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction")
 	err = client.Revert(snapshotID)
 	test.ErrFailWithDesc(err, t, "Restoring snapshot")
@@ -463,8 +476,12 @@ func TestResendRelayTransaction(t *testing.T) {
 
 	// Advance time
 	clk.IncrementBySeconds(6 * 60)
-	newTx, err := relay.UpdateUnconfirmedTransactions()
+	newTxs, err := relay.UpdateUnconfirmedTransactions(context.Background())
 	test.ErrFailWithDesc(err, t, "Updating unconfirmed transactions")
+	if len(newTxs) != 1 {
+		t.Fatalf("Expected 1 tx to be resent but got %v", len(newTxs))
+	}
+	newTx := newTxs[0]
 
 	// Check transaction was now sent with increased gas price
 	client.MineBlocks(2)
@@ -473,6 +490,23 @@ func TestResendRelayTransaction(t *testing.T) {
 		t.Errorf("Gas price of resent transaction is incorrect: expected %v but was %v", 2400, newTx.GasPrice().Int64())
 	}
 
+	// Check both attempts are kept, and the tx can be found by its original hash
+	storedTx, err := relay.TxStore.GetTransactionByHash(signedTx.Hash())
+	test.ErrFailWithDesc(err, t, "Getting transaction by original hash")
+	if storedTx == nil || len(storedTx.Attempts) != 2 || storedTx.Attempts[0].Hash != signedTx.Hash() || storedTx.Attempts[1].Hash != newTx.Hash() {
+		t.Errorf("Expected attempts %v and %v to be stored but got %v", signedTx.Hash().Hex(), newTx.Hash().Hex(), storedTx)
+	}
+	status, err := relay.TransactionStatusByHash(context.Background(), signedTx.Hash())
+	test.ErrFailWithDesc(err, t, "Getting status of the original transaction")
+	if status.State != TxStateReplaced || *status.ReplacedBy != newTx.Hash() {
+		t.Errorf("Expected original tx to be replaced by %v but got %v", newTx.Hash().Hex(), status)
+	}
+	status, err = relay.TransactionStatusByNonce(context.Background(), newTx.Nonce())
+	test.ErrFailWithDesc(err, t, "Getting status of the resent transaction")
+	if status.State != TxStateMined || status.Hash != newTx.Hash() || status.HubEvent == nil || status.HubEvent.Status != "OK" {
+		t.Errorf("Expected resent tx to be mined and relayed but got %v", status)
+	}
+
 	// Check the tx is removed from the store after enough blocks
 	client.MineBlocks(12)
 	assertNoTransactionResent(t, relay.RelayServer)
@@ -480,6 +514,11 @@ func TestResendRelayTransaction(t *testing.T) {
 	if missingTx != nil || err != nil {
 		t.Errorf("Transaction %v was not removed from store after 12 confirmations (error %v)", missingTx.Hash().Hex(), err)
 	}
+	status, err = relay.TransactionStatusByHash(context.Background(), newTx.Hash())
+	test.ErrFailWithDesc(err, t, "Getting status of the confirmed transaction")
+	if status == nil || status.State != TxStateConfirmed || status.Confirmations < confirmationsNeeded {
+		t.Errorf("Expected resent tx to be confirmed but got %v", status)
+	}
 }
 
 func TestMultipleRelayTransactions(t *testing.T) {
@@ -489,15 +528,15 @@ func TestMultipleRelayTransactions(t *testing.T) {
 	request3 := newRelayTransactionRequest(t, 4, "0xc1f1beeb6677e93d01ee134c075636174636324996512000ef945c246439ce061283fa2593040957d43e3c8940abded04fadbbfcd577f1c5a0be2a1574984c581b")
 
 	// Send 3 transactions, separated by 1 min each, and revert the last 2
-	signedTx1, err := relay.CreateRelayTransaction(request1)
+	signedTx1, err := relay.CreateRelayTransaction(context.Background(), request1, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction 1")
 	clk.IncrementBySeconds(60)
 	snapshotID, err := client.Snapshot()
 	test.ErrFailWithDesc(err, t, "Creating snapshot")
-	_, err = relay.CreateRelayTransaction(request2)
+	_, err = relay.CreateRelayTransaction(context.Background(), request2, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction 2")
 	clk.IncrementBySeconds(60)
-	signedTx3, err := relay.CreateRelayTransaction(request3)
+	signedTx3, err := relay.CreateRelayTransaction(context.Background(), request3, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction 3")
 	err = client.Revert(snapshotID)
 	test.ErrFailWithDesc(err, t, "Restoring snapshot")
@@ -511,18 +550,18 @@ func TestMultipleRelayTransactions(t *testing.T) {
 	assertNoTransactionResent(t, relay.RelayServer)
 	assertRelayNonce(t, nonce)
 
-	// Mine a bunch of blocks, so tx1 is confirmed and tx2 is resent
+	// Mine a bunch of blocks, so tx1 is confirmed and both tx2 and tx3 are resent in nonce order
 	client.MineBlocks(12)
-	newTx2, err := relay.UpdateUnconfirmedTransactions()
+	newTxs, err := relay.UpdateUnconfirmedTransactions(context.Background())
 	test.ErrFailWithDesc(err, t, "Updating unconfirmed transactions")
-	assertRelayNonce(t, nonce+1)
-	assertTransactionRelayed(t, newTx2.Hash())
-
-	// Reinject tx3 into the chain as if it were mined once tx2 goes through
-	test.ErrFailWithDesc(client.SendTransaction(context.Background(), signedTx3), t, "Resending tx3")
-	assertTransactionRelayed(t, signedTx3.Hash())
+	if len(newTxs) != 2 || newTxs[0].Nonce() != nonce || newTxs[1].Nonce() != signedTx3.Nonce() {
+		t.Fatalf("Expected txs with nonces %v and %v to be resent but got %v", nonce, signedTx3.Nonce(), newTxs)
+	}
+	assertRelayNonce(t, nonce+2)
+	assertTransactionRelayed(t, newTxs[0].Hash())
+	assertTransactionRelayed(t, newTxs[1].Hash())
 
-	// Check that tx3 does not get resent, even after time passes or blocks get mined, and that store is empty
+	// Check that tx3 does not get resent again, even after time passes or blocks get mined, and that store is empty
 	assertNoTransactionResent(t, relay.RelayServer)
 	clk.IncrementBySeconds(300)
 	client.MineBlocks(12)
@@ -540,7 +579,7 @@ func TestReuseNonceOnDevMode(t *testing.T) {
 	// Relay a tx
 	snapshotID, err := client.Snapshot()
 	test.ErrFailWithDesc(err, t, "Creating snapshot")
-	signedTx1, err := relay.CreateRelayTransaction(request)
+	signedTx1, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
 	if err != nil {
 		t.Errorf("CreateRelayTransaction error %v", err)
 		return
@@ -549,14 +588,14 @@ func TestReuseNonceOnDevMode(t *testing.T) {
 
 	// Revert blockchain state and resend it, failing with "the tx doesn't have the correct nonce"
 	test.ErrFailWithDesc(client.Revert(snapshotID), t, "Restoring snapshot")
-	noTx, err := relay.CreateRelayTransaction(request)
+	noTx, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
 	if noTx != nil || err == nil {
 		t.Errorf("Expected relay operation to fail due to nonce")
 	}
 
 	// Disable nonce cache and retry successfully
 	relay.DevMode = true
-	signedTx2, err := relay.CreateRelayTransaction(request)
+	signedTx2, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
 	test.ErrFailWithDesc(err, t, "Sending tx with old nonce on dev mode")
 	assertTransactionRelayed(t, signedTx2.Hash())
 
@@ -578,12 +617,12 @@ func TestTransactionTotalGasCost(t *testing.T) {
 	request2.EncodedFunction = "0xb51fab0a0000000000000000000000000000000000000000000000000000000000000020000000000000000000000000000000000000000000000000000000000000000d68656c6c6f20776f726c64610000000000000000000000000000000000000000"
 
 	// Send the 2 transactions
-	signedTx1, err := relay.CreateRelayTransaction(request1)
+	signedTx1, err := relay.CreateRelayTransaction(context.Background(), request1, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction 1")
 	clk.IncrementBySeconds(60)
 	receipt1, err := client.TransactionReceipt(context.Background(), signedTx1.Hash())
 	test.ErrFailWithDesc(err, t, fmt.Sprint("Fetching transaction receipt for hash ", signedTx1.Hash()))
-	signedTx2, err := relay.CreateRelayTransaction(request2)
+	signedTx2, err := relay.CreateRelayTransaction(context.Background(), request2, t.Name())
 	test.ErrFailWithDesc(err, t, "Creating relay transaction 2")
 	clk.IncrementBySeconds(60)
 	receipt2, err := client.TransactionReceipt(context.Background(), signedTx2.Hash())
@@ -612,3 +651,101 @@ func TestGetEncodedFunctionGas(t *testing.T) {
 		test.ErrFail(errors.New("Wrong gas calculation"), t)
 	}
 }
+
+func TestCreateRelayTransactionWrongPaymasterHub(t *testing.T) {
+	parsed, err := abi.JSON(strings.NewReader(testcontracts.TestSponsorABI))
+	test.ErrFail(err, t)
+	ownerAuth := bind.NewKeyedTransactor(ownerKey3)
+	ownerAuth.GasLimit = 4000000
+	// A paymaster that was never pointed at our RelayHub
+	otherSponsor, _, _, err := bind.DeployContract(ownerAuth, parsed, common.FromHex(testcontracts.TestSponsorBin), client)
+	test.ErrFailWithDesc(err, t, "Deploying TestSponsor contract")
+	client.Commit()
+
+	request := newRelayTransactionRequest(t, 6, "0x00")
+	request.Paymaster = otherSponsor
+	noTx, err := relay.CreateRelayTransaction(context.Background(), request, t.Name())
+	if noTx != nil || err == nil || AsRelayError(err).Code != ErrWrongHub || !strings.Contains(err.Error(), "Wrong paymaster hub address") {
+		t.Errorf("Expected relay operation to fail due to paymaster hub address, but got tx %v (error %v)", noTx, err)
+	}
+}
+
+// TestRelayCallPreCheckGas measures the gas relayCall() spends before RelayHub checks gasleft(): the lowest gas limit
+// that passes the check, minus the intrinsic gas and the GasReserve and required gas that must be left at it. The
+// request need not be relayable, as canRelay() only runs after the check and does not revert.
+func TestRelayCallPreCheckGas(t *testing.T) {
+	ctx := context.Background()
+	request := newRelayTransactionRequest(t, 0, "0x00")
+	callOpt := &bind.CallOpts{From: relay.Address(), Context: ctx}
+
+	paymaster, err := relay.paymaster(ctx, request.Paymaster)
+	test.ErrFailWithDesc(err, t, "Binding paymaster")
+	gasLimits, err := paymaster.GetGasLimits(callOpt)
+	test.ErrFailWithDesc(err, t, "Getting paymaster gas limits")
+	hubOverhead, err := relay.rhub.GetHubOverhead(callOpt)
+	test.ErrFailWithDesc(err, t, "Getting hub overhead")
+	requiredGas := hubOverhead.Uint64() + gasLimits.AcceptRelayedCallGasLimit.Uint64() +
+		gasLimits.PreRelayedCallGasLimit.Uint64() + request.GasLimit.Uint64() + gasLimits.PostRelayedCallGasLimit.Uint64()
+
+	relayRequest := librelay.GSNTypesRelayRequest{
+		Target:          request.To,
+		EncodedFunction: common.FromHex(request.EncodedFunction),
+		GasData: librelay.GSNTypesGasData{
+			GasLimit:     &request.GasLimit,
+			GasPrice:     &request.GasPrice,
+			PctRelayFee:  &request.PercentRelayFee,
+			BaseRelayFee: &request.BaseRelayFee,
+		},
+		RelayData: librelay.GSNTypesRelayData{
+			SenderAddress: request.From,
+			SenderNonce:   &request.SenderNonce,
+			RelayAddress:  relay.Address(),
+			Paymaster:     request.Paymaster,
+		},
+	}
+	data, err := relay.rhubABI.Pack("relayCall", relayRequest, request.Signature, request.ApprovalData)
+	test.ErrFailWithDesc(err, t, "Encoding relayCall()")
+	intrinsicGas, err := relay.intrinsicGas(ctx, data)
+	test.ErrFailWithDesc(err, t, "Computing intrinsic gas")
+
+	passesCheck := func(gasLimit uint64) bool {
+		msg := ethereum.CallMsg{From: relay.Address(), To: &rhaddr, Gas: gasLimit, GasPrice: &request.GasPrice, Data: data}
+		_, err := client.CallContract(ctx, msg, nil)
+		return err == nil
+	}
+	low, high := intrinsicGas+GasReserve+requiredGas, relayCallGasLimit(intrinsicGas, requiredGas)
+	if passesCheck(low) {
+		t.Fatalf("relayCall() passed RelayHub's gas check with no gas to spend before it (gas limit %d)", low)
+	}
+	if !passesCheck(high) {
+		t.Fatalf("relayCall() failed with the relay's gas limit %d", high)
+	}
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		if passesCheck(mid) {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	preCheckGas := high - intrinsicGas - GasReserve - requiredGas
+	t.Logf("relayCall() spends %d gas before RelayHub's gas check, RelayCallPreCheckGas is %d", preCheckGas, RelayCallPreCheckGas)
+	if preCheckGas > RelayCallPreCheckGas {
+		t.Errorf("relayCall() spends %d gas before RelayHub's gas check, more than RelayCallPreCheckGas %d", preCheckGas, RelayCallPreCheckGas)
+	}
+}
+
+func TestCalldataGas(t *testing.T) {
+	// 2 zero bytes and 3 non-zero bytes
+	data := common.FromHex("0x0001020300")
+	if gas := calldataGas(data, params.TxDataNonZeroGasFrontier); gas != 2*4+3*68 {
+		t.Errorf("Wrong pre-Istanbul calldata gas: %v", gas)
+	}
+	if gas := calldataGas(data, params.TxDataNonZeroGasEIP2028); gas != 2*4+3*16 {
+		t.Errorf("Wrong Istanbul calldata gas: %v", gas)
+	}
+	if gas := calldataGas(nil, params.TxDataNonZeroGasEIP2028); gas != 0 {
+		t.Errorf("Wrong calldata gas for empty data: %v", gas)
+	}
+}
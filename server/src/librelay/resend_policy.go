@@ -0,0 +1,135 @@
+package librelay
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Names of the fee bumps selectable by ResendPolicyConfig.Type
+const (
+	LinearResendPolicyType      = "linear"
+	ExponentialResendPolicyType = "exponential"
+	OracleResendPolicyType      = "oracle"
+)
+
+// ResendPolicyConfig selects and parameterizes how the relay replaces its stuck transactions
+type ResendPolicyConfig struct {
+	Type                string
+	Percent             int64         // fee increase per attempt, see the FeeBump implementations
+	Timeout             time.Duration // how long a transaction may stay pending before it is resent
+	MaxGasPrice         int64         // highest gas price (or max fee per gas) of a replacement
+	MaxTotalCostPercent int64         // highest total cost of the replacements sent in one pass, as percentage of the relay's balance
+}
+
+func DefaultResendPolicyConfig() ResendPolicyConfig {
+	return ResendPolicyConfig{
+		Type:                LinearResendPolicyType,
+		Percent:             20,
+		Timeout:             5 * time.Minute,
+		MaxGasPrice:         100e9,
+		MaxTotalCostPercent: 100,
+	}
+}
+
+func (config *ResendPolicyConfig) Dump() {
+	log.Info("ResendPolicy", "type", config.Type, "percent", config.Percent, "timeout", config.Timeout,
+		"maxGasPrice", config.MaxGasPrice, "maxTotalCostPercent", config.MaxTotalCostPercent)
+}
+
+// ResendPolicy decides when a pending transaction is stuck, and the fees of its replacement
+type ResendPolicy struct {
+	Bump                FeeBump
+	Timeout             time.Duration
+	MaxGasPrice         *big.Int
+	MaxTotalCostPercent int64
+}
+
+func NewResendPolicy(config ResendPolicyConfig) (policy *ResendPolicy, err error) {
+	if config.Percent < 0 || config.Timeout <= 0 || config.MaxGasPrice <= 0 || config.MaxTotalCostPercent <= 0 {
+		return nil, fmt.Errorf("invalid resend policy: percent %d timeout %s max gas price %d max total cost percent %d",
+			config.Percent, config.Timeout, config.MaxGasPrice, config.MaxTotalCostPercent)
+	}
+
+	policy = &ResendPolicy{
+		Timeout:             config.Timeout,
+		MaxGasPrice:         big.NewInt(config.MaxGasPrice),
+		MaxTotalCostPercent: config.MaxTotalCostPercent,
+	}
+	switch config.Type {
+	case "", LinearResendPolicyType:
+		policy.Bump = &LinearFeeBump{Percent: config.Percent}
+	case ExponentialResendPolicyType:
+		policy.Bump = &ExponentialFeeBump{Percent: config.Percent}
+	case OracleResendPolicyType:
+		policy.Bump = &OracleFeeBump{Percent: config.Percent}
+	default:
+		return nil, fmt.Errorf("unknown resend policy %q", config.Type)
+	}
+	return
+}
+
+// NextFee returns the fee (the gas price, or either of the EIP-1559 fee caps) of the given attempt to replace a
+// transaction, never less than what nodes accept as a replacement of the previous attempt. Returns an error if that
+// requires exceeding MaxGasPrice.
+func (policy *ResendPolicy) NextFee(original, previous, current *big.Int, attempt int) (fee *big.Int, err error) {
+	fee = policy.Bump.NextFee(original, previous, current, attempt)
+	if minimal := minimalReplacementFee(previous); fee.Cmp(minimal) < 0 {
+		fee = minimal
+	}
+	if fee.Cmp(policy.MaxGasPrice) > 0 {
+		log.Warn("Capping gas price to max value", "maxGasPrice", policy.MaxGasPrice)
+		fee = new(big.Int).Set(policy.MaxGasPrice)
+		if fee.Cmp(minimalReplacementFee(previous)) < 0 {
+			return nil, fmt.Errorf("cannot bump fee %s by %d%% without exceeding max gas price %s", previous, replacementFeeBumpPercent, policy.MaxGasPrice)
+		}
+	}
+	return
+}
+
+// MaxTotalCost returns the highest total cost of the replacements sent in one pass, given the relay's balance
+func (policy *ResendPolicy) MaxTotalCost(balance *big.Int) *big.Int {
+	maxCost := new(big.Int).Mul(balance, big.NewInt(policy.MaxTotalCostPercent))
+	return maxCost.Div(maxCost, big.NewInt(100))
+}
+
+// FeeBump computes the fee of a replacement from the fee of the original transaction, the fee of the previous
+// attempt, the fee currently suggested for new transactions (nil if unknown) and the attempt number, starting at 1
+type FeeBump interface {
+	NextFee(original, previous, current *big.Int, attempt int) *big.Int
+}
+
+// LinearFeeBump adds Percent percent of the original fee on every attempt
+type LinearFeeBump struct {
+	Percent int64
+}
+
+func (bump *LinearFeeBump) NextFee(original, previous, current *big.Int, attempt int) *big.Int {
+	fee := new(big.Int).Mul(original, big.NewInt(100+bump.Percent*int64(attempt)))
+	return fee.Div(fee, big.NewInt(100))
+}
+
+// ExponentialFeeBump adds Percent percent of the previous fee on every attempt
+type ExponentialFeeBump struct {
+	Percent int64
+}
+
+func (bump *ExponentialFeeBump) NextFee(original, previous, current *big.Int, attempt int) *big.Int {
+	fee := new(big.Int).Mul(previous, big.NewInt(100+bump.Percent))
+	return fee.Div(fee, big.NewInt(100))
+}
+
+// OracleFeeBump follows the currently suggested fee, increased by Percent percent
+type OracleFeeBump struct {
+	Percent int64
+}
+
+func (bump *OracleFeeBump) NextFee(original, previous, current *big.Int, attempt int) *big.Int {
+	if current == nil {
+		return new(big.Int).Set(previous)
+	}
+	fee := new(big.Int).Mul(current, big.NewInt(100+bump.Percent))
+	return fee.Div(fee, big.NewInt(100))
+}
@@ -0,0 +1,58 @@
+package librelay
+
+import (
+	"math/big"
+	"openeth.dev/librelay/test"
+	"testing"
+	"time"
+)
+
+func assertNextFee(t *testing.T, policy *ResendPolicy, original, previous, current int64, attempt int, expected int64) {
+	t.Helper()
+	var currentFee *big.Int
+	if current != 0 {
+		currentFee = big.NewInt(current)
+	}
+	fee, err := policy.NextFee(big.NewInt(original), big.NewInt(previous), currentFee, attempt)
+	test.ErrFail(err, t)
+	if fee.Cmp(big.NewInt(expected)) != 0 {
+		t.Errorf("Expected fee %d on attempt %d but got %v", expected, attempt, fee)
+	}
+}
+
+func TestResendPolicy(t *testing.T) {
+	config := ResendPolicyConfig{Type: LinearResendPolicyType, Percent: 20, Timeout: time.Minute, MaxGasPrice: 1000, MaxTotalCostPercent: 50}
+	linear, err := NewResendPolicy(config)
+	test.ErrFail(err, t)
+	assertNextFee(t, linear, 100, 100, 0, 1, 120)
+	assertNextFee(t, linear, 100, 120, 0, 2, 140)
+	// Nodes require at least a 10% bump, rounded up
+	assertNextFee(t, linear, 100, 200, 0, 6, 220)
+	assertNextFee(t, linear, 100, 220, 0, 7, 242)
+
+	config.Type = ExponentialResendPolicyType
+	exponential, err := NewResendPolicy(config)
+	test.ErrFail(err, t)
+	assertNextFee(t, exponential, 100, 120, 0, 2, 144)
+
+	config.Type = OracleResendPolicyType
+	oracle, err := NewResendPolicy(config)
+	test.ErrFail(err, t)
+	assertNextFee(t, oracle, 100, 100, 300, 1, 360)
+	assertNextFee(t, oracle, 100, 100, 50, 1, 110)
+
+	// Capped at the max gas price, unless that is not enough to replace the previous attempt
+	assertNextFee(t, exponential, 100, 900, 0, 5, 1000)
+	if _, err = exponential.NextFee(big.NewInt(100), big.NewInt(950), nil, 6); err == nil {
+		t.Error("Expected an error bumping over the max gas price")
+	}
+
+	if maxCost := linear.MaxTotalCost(big.NewInt(1000)); maxCost.Cmp(big.NewInt(500)) != 0 {
+		t.Errorf("Expected max total cost 500 but got %v", maxCost)
+	}
+
+	config.Type = "unknown"
+	if _, err = NewResendPolicy(config); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,185 @@
+package librelay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Signer holds the relay's key, which may live out of the relay process. It only signs transactions: neither Clef nor
+// nodes sign raw hashes, which could be the hashes of arbitrary transactions, and the relay signs nothing else.
+type Signer interface {
+	Address() common.Address
+	// SignTx returns tx signed for chainID
+	SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+}
+
+// Names of the signers selectable by SignerConfig.Type
+const (
+	LocalSignerType  = "local"
+	RemoteSignerType = "remote"
+)
+
+// Namespaces of the remote signers' signTransaction method
+const (
+	ClefSignerAPI = "account" // Clef's account_signTransaction
+	EthSignerAPI  = "eth"     // a node's eth_signTransaction
+)
+
+// SignerConfig selects where the relay's key lives. The local signer's key is read from the keystore by the caller.
+type SignerConfig struct {
+	Type string
+
+	RemoteURL     string         // remote: JSON-RPC endpoint of the signer
+	RemoteAddress common.Address // remote: the signing account
+	RemoteAPI     string         // remote: ClefSignerAPI or EthSignerAPI
+	RemoteTimeout time.Duration  // remote: longest time waiting for a signature, which Clef may ask an operator to approve
+}
+
+func (config *SignerConfig) Dump() {
+	ctx := []interface{}{"type", config.Type}
+	if config.Type == RemoteSignerType {
+		ctx = append(ctx, "url", config.RemoteURL, "address", config.RemoteAddress, "api", config.RemoteAPI, "timeout", config.RemoteTimeout)
+	}
+	log.Info("Signer", ctx...)
+}
+
+func (config *SignerConfig) validate() error {
+	switch config.Type {
+	case "", LocalSignerType:
+		return nil
+	case RemoteSignerType:
+	default:
+		return fmt.Errorf("unknown signer %q", config.Type)
+	}
+	if config.RemoteAPI != "" && config.RemoteAPI != ClefSignerAPI && config.RemoteAPI != EthSignerAPI {
+		return fmt.Errorf("unknown remote signer api %q", config.RemoteAPI)
+	}
+	if config.RemoteURL == "" || config.RemoteAddress == (common.Address{}) || config.RemoteTimeout <= 0 {
+		return fmt.Errorf("invalid remote signer: url %q address %s timeout %s", config.RemoteURL, config.RemoteAddress.Hex(), config.RemoteTimeout)
+	}
+	return nil
+}
+
+// LocalSigner signs with a key held in memory
+type LocalSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewLocalSigner(key *ecdsa.PrivateKey) *LocalSigner {
+	return &LocalSigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (signer *LocalSigner) Address() common.Address {
+	return signer.address
+}
+
+func (signer *LocalSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), signer.key)
+}
+
+// RemoteSigner sends transactions to sign to a JSON-RPC signer, such as Clef, so the key stays out of the relay
+// process
+type RemoteSigner struct {
+	client  *rpc.Client
+	address common.Address
+	method  string
+	timeout time.Duration
+}
+
+// remoteSignTxArgs are the arguments of account_signTransaction and eth_signTransaction
+type remoteSignTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                hexutil.Big     `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId,omitempty"`
+}
+
+type remoteSignTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func NewRemoteSigner(config SignerConfig) (*RemoteSigner, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	api := config.RemoteAPI
+	if api == "" {
+		api = ClefSignerAPI
+	}
+	client, err := rpc.Dial(config.RemoteURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteSigner{client: client, address: config.RemoteAddress, method: api + "_signTransaction", timeout: config.RemoteTimeout}, nil
+}
+
+func (signer *RemoteSigner) Address() common.Address {
+	return signer.address
+}
+
+// SignTx checks the remote signer signed tx unchanged with the expected account, as it is trusted with the key only
+func (signer *RemoteSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := remoteSignTxArgs{
+		From:    signer.address,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   hexutil.Big(*tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainID: (*hexutil.Big)(chainID),
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", tx.Type())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, signer.timeout)
+	defer cancel()
+	var result remoteSignTxResult
+	if err := signer.client.CallContext(ctx, &result, signer.method, args); err != nil {
+		return nil, fmt.Errorf("%s failed: %w", signer.method, err)
+	}
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(result.Raw); err != nil {
+		return nil, fmt.Errorf("%s returned an invalid transaction: %w", signer.method, err)
+	}
+
+	txSigner := types.LatestSignerForChainID(chainID)
+	if signedTx.Type() != tx.Type() || txSigner.Hash(signedTx) != txSigner.Hash(tx) {
+		return nil, fmt.Errorf("%s returned a different transaction %s", signer.method, signedTx.Hash().Hex())
+	}
+	sender, err := types.Sender(txSigner, signedTx)
+	if err != nil {
+		return nil, err
+	}
+	if sender != signer.address {
+		return nil, fmt.Errorf("%s signed with %s instead of %s", signer.method, sender.Hex(), signer.address.Hex())
+	}
+	return signedTx, nil
+}
+
+func (signer *RemoteSigner) Close() {
+	signer.client.Close()
+}
@@ -0,0 +1,113 @@
+package librelay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"openeth.dev/librelay/test"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// stubSigner serves account_signTransaction as Clef does, signing with key
+type stubSigner struct {
+	key       *ecdsa.PrivateKey
+	tampering bool // whether it raises the value before signing
+}
+
+func (stub *stubSigner) SignTransaction(args remoteSignTxArgs) (*remoteSignTxResult, error) {
+	chainID := args.ChainID.ToInt()
+	value := args.Value.ToInt()
+	if stub.tampering {
+		value = new(big.Int).Add(value, big.NewInt(1))
+	}
+	var tx *types.Transaction
+	if args.MaxFeePerGas != nil {
+		tx = types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: uint64(args.Nonce), GasTipCap: args.MaxPriorityFeePerGas.ToInt(),
+			GasFeeCap: args.MaxFeePerGas.ToInt(), Gas: uint64(args.Gas), To: args.To, Value: value, Data: args.Data})
+	} else {
+		tx = types.NewTransaction(uint64(args.Nonce), *args.To, value, uint64(args.Gas), args.GasPrice.ToInt(), args.Data)
+	}
+	signedTx, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), stub.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signedTx.MarshalBinary()
+	return &remoteSignTxResult{Raw: raw}, err
+}
+
+func newStubRemoteSigner(t *testing.T, stub *stubSigner, address common.Address) (*RemoteSigner, func()) {
+	server := rpc.NewServer()
+	test.ErrFail(server.RegisterName(ClefSignerAPI, stub), t)
+	httpServer := httptest.NewServer(server)
+	signer, err := NewRemoteSigner(SignerConfig{Type: RemoteSignerType, RemoteURL: httpServer.URL, RemoteAddress: address, RemoteTimeout: time.Second})
+	test.ErrFail(err, t)
+	return signer, func() {
+		signer.Close()
+		httpServer.Close()
+		server.Stop()
+	}
+}
+
+func TestLocalSigner(t *testing.T) {
+	chainID := big.NewInt(1337)
+	key, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	signer := NewLocalSigner(key)
+	signedTx, err := signer.SignTx(context.Background(), newNonceTx(7), chainID)
+	test.ErrFail(err, t)
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), signedTx)
+	test.ErrFail(err, t)
+	if sender != signer.Address() {
+		t.Errorf("Expected the transaction to be signed by %s, got %s", signer.Address().Hex(), sender.Hex())
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+	chainID := big.NewInt(1337)
+	key, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	to := common.HexToAddress("ffcf8fdee72ac11b5c542428b35eef5769c409f0")
+	txs := map[string]*types.Transaction{
+		"legacy": types.NewTransaction(7, to, big.NewInt(1), 50000, big.NewInt(10), []byte{1, 2}),
+		"dynamic fee": types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: 7, GasTipCap: big.NewInt(2), GasFeeCap: big.NewInt(10),
+			Gas: 50000, To: &to, Value: big.NewInt(1), Data: []byte{1, 2}}),
+	}
+
+	signer, closeSigner := newStubRemoteSigner(t, &stubSigner{key: key}, address)
+	defer closeSigner()
+	for name, tx := range txs {
+		signedTx, err := signer.SignTx(ctx, tx, chainID)
+		if err != nil {
+			t.Errorf("Expected the %s transaction to be signed, got %v", name, err)
+			continue
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(chainID), signedTx)
+		test.ErrFail(err, t)
+		if sender != address || signedTx.Value().Cmp(tx.Value()) != 0 {
+			t.Errorf("Expected the %s transaction signed unchanged by %s, got sender %s value %s", name, address.Hex(), sender.Hex(), signedTx.Value())
+		}
+	}
+
+	tampering, closeTampering := newStubRemoteSigner(t, &stubSigner{key: key, tampering: true}, address)
+	defer closeTampering()
+	if _, err = tampering.SignTx(ctx, txs["legacy"], chainID); err == nil {
+		t.Errorf("Expected a changed transaction to be refused")
+	}
+
+	otherKey, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	wrongKey, closeWrongKey := newStubRemoteSigner(t, &stubSigner{key: otherKey}, address)
+	defer closeWrongKey()
+	if _, err = wrongKey.SignTx(ctx, txs["legacy"], chainID); err == nil {
+		t.Errorf("Expected a transaction signed by another account to be refused")
+	}
+}
@@ -0,0 +1,92 @@
+package librelay
+
+import (
+	"errors"
+	"fmt"
+	"openeth.dev/librelay/txstore"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ErrRepeatedNonce is returned when signing a transaction with the nonce of a different one signed before
+var ErrRepeatedNonce = errors.New("nonce already signed with a different payload")
+
+// SigningGuard keeps the relay from signing two different transactions with the same nonce, for which RelayHub's
+// penalizeRepeatedNonce slashes its stake. It records the payload (data, gas limit, recipient and value) signed with
+// each nonce, and only allows signing the same payload again with that nonce, i.e. bumping its fees.
+type SigningGuard struct {
+	store    txstore.ISignedNonceStore
+	mutex    *sync.Mutex
+	warnOnly bool // log repeated nonces instead of refusing to sign them, for dev mode, where the chain gets reverted
+}
+
+func NewSigningGuard(store txstore.ISignedNonceStore, warnOnly bool) *SigningGuard {
+	return &SigningGuard{store: store, mutex: &sync.Mutex{}, warnOnly: warnOnly}
+}
+
+// payloadHash hashes the fields compared by penalizeRepeatedNonce, which ignores fees
+func payloadHash(tx *types.Transaction) (hash common.Hash, err error) {
+	payload, err := rlp.EncodeToBytes([]interface{}{tx.Data(), tx.Gas(), tx.To(), tx.Value()})
+	if err != nil {
+		return
+	}
+	return crypto.Keccak256Hash(payload), nil
+}
+
+// Sign signs tx with sign, unless a different payload was signed with its nonce before, and records its payload
+func (guard *SigningGuard) Sign(tx *types.Transaction, sign func(*types.Transaction) (*types.Transaction, error)) (signedTx *types.Transaction, err error) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	hash, err := payloadHash(tx)
+	if err != nil {
+		return
+	}
+	signed, found, err := guard.store.GetSignedPayload(tx.Nonce())
+	if err != nil {
+		return
+	}
+	if found && signed != hash {
+		if !guard.warnOnly {
+			return nil, fmt.Errorf("%w: nonce %d", ErrRepeatedNonce, tx.Nonce())
+		}
+		log.Warn("SigningGuard: signing a different payload with a used nonce", "nonce", tx.Nonce())
+	}
+
+	signedTx, err = sign(tx)
+	if err != nil {
+		return
+	}
+	if !found || signed != hash {
+		err = guard.store.SaveSignedPayload(tx.Nonce(), hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Discard forgets the payload of a signed tx, when it did not leave the relay so its nonce can be used again
+func (guard *SigningGuard) Discard(tx *types.Transaction) (err error) {
+	guard.mutex.Lock()
+	defer guard.mutex.Unlock()
+
+	hash, err := payloadHash(tx)
+	if err != nil {
+		return
+	}
+	signed, found, err := guard.store.GetSignedPayload(tx.Nonce())
+	if err != nil || !found || signed != hash {
+		return
+	}
+	return guard.store.RemoveSignedPayload(tx.Nonce())
+}
+
+func (guard *SigningGuard) Close() (err error) {
+	return guard.store.Close()
+}
@@ -0,0 +1,70 @@
+package librelay
+
+import (
+	"errors"
+	"math/big"
+	"openeth.dev/librelay/test"
+	"openeth.dev/librelay/txstore"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSigningGuard(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	signer := types.LatestSignerForChainID(big.NewInt(1337))
+	sign := func(tx *types.Transaction) (*types.Transaction, error) {
+		return types.SignTx(tx, signer, key)
+	}
+	to := common.HexToAddress("ffcf8fdee72ac11b5c542428b35eef5769c409f0")
+	original := types.NewTransaction(7, to, big.NewInt(1), 50000, big.NewInt(10), []byte{1, 2})
+
+	os.RemoveAll("signed-nonces-test.db")
+	defer os.RemoveAll("signed-nonces-test.db")
+	store, err := txstore.NewLevelDbSignedNonceStore("signed-nonces-test.db")
+	test.ErrFail(err, t)
+	guard := NewSigningGuard(store, false)
+	_, err = guard.Sign(original, sign)
+	test.ErrFail(err, t)
+
+	// Records survive restarts
+	test.ErrFail(guard.Close(), t)
+	store, err = txstore.NewLevelDbSignedNonceStore("signed-nonces-test.db")
+	test.ErrFail(err, t)
+	guard = NewSigningGuard(store, false)
+	defer guard.Close()
+
+	bump := types.NewTransaction(7, to, big.NewInt(1), 50000, big.NewInt(20), []byte{1, 2})
+	if _, err = guard.Sign(bump, sign); err != nil {
+		t.Errorf("Expected a gas price bump to be signed, got %v", err)
+	}
+	for name, tx := range map[string]*types.Transaction{
+		"data":  types.NewTransaction(7, to, big.NewInt(1), 50000, big.NewInt(20), []byte{1, 3}),
+		"to":    types.NewTransaction(7, common.Address{}, big.NewInt(1), 50000, big.NewInt(20), []byte{1, 2}),
+		"value": types.NewTransaction(7, to, big.NewInt(2), 50000, big.NewInt(20), []byte{1, 2}),
+		"gas":   types.NewTransaction(7, to, big.NewInt(1), 50001, big.NewInt(20), []byte{1, 2}),
+	} {
+		if _, err = guard.Sign(tx, sign); !errors.Is(err, ErrRepeatedNonce) {
+			t.Errorf("Expected a different %v with a used nonce to be refused, got %v", name, err)
+		}
+	}
+
+	other := types.NewTransaction(8, to, big.NewInt(1), 50000, big.NewInt(20), nil)
+	_, err = guard.Sign(other, sign)
+	test.ErrFail(err, t)
+	test.ErrFail(guard.Discard(other), t)
+	if _, err = guard.Sign(types.NewTransaction(8, to, big.NewInt(0), 21000, big.NewInt(20), nil), sign); err != nil {
+		t.Errorf("Expected the nonce of a discarded tx to be signed again, got %v", err)
+	}
+
+	warnOnly := NewSigningGuard(txstore.NewMemorySignedNonceStore(), true)
+	_, err = warnOnly.Sign(original, sign)
+	test.ErrFail(err, t)
+	if _, err = warnOnly.Sign(types.NewTransaction(7, to, big.NewInt(0), 21000, big.NewInt(20), nil), sign); err != nil {
+		t.Errorf("Expected a warn only guard to sign a repeated nonce, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package librelay
+
+import (
+	"context"
+	"math/big"
+	"openeth.dev/librelay/txstore"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// States of a relay's transaction, as reported by TransactionStatus
+const (
+	TxStatePending   = "pending"   // sent but not mined yet
+	TxStateMined     = "mined"     // mined, but with less than confirmationsNeeded confirmations
+	TxStateConfirmed = "confirmed" // mined with at least confirmationsNeeded confirmations
+	TxStateReplaced  = "replaced"  // the queried hash was replaced by another attempt with the same nonce, see ReplacedBy
+	TxStateDropped   = "dropped"   // no attempt was mined, but another transaction was mined with the same nonce
+)
+
+// Names of RelayHub's RelayCallStatus values, reported by TransactionRelayed events
+var relayCallStatusNames = []string{"OK", "RelayedCallFailed", "PreRelayedFailed", "PostRelayedFailed", "RecipientBalanceChanged"}
+
+type TransactionStatus struct {
+	State         string
+	Nonce         uint64
+	Hash          common.Hash  // the mined attempt, or the latest one if none was mined
+	ReplacedBy    *common.Hash // set if the queried hash is not the current one
+	Attempts      []common.Hash
+	BlockNumber   *big.Int
+	Confirmations uint64
+	HubEvent      *HubEvent // the relayed call's outcome, once mined
+}
+
+// HubEvent is the TransactionRelayed or CanRelayFailed event emitted by RelayHub for a relayed call
+type HubEvent struct {
+	Name      string
+	From      common.Address
+	To        common.Address
+	Paymaster common.Address
+	Status    string   // TransactionRelayed only: the RelayCallStatus name
+	Charge    *big.Int // TransactionRelayed only
+	Reason    *big.Int // CanRelayFailed only
+}
+
+// TransactionStatusByHash returns the status of a transaction sent by the relay, given the hash of any of its attempts.
+// Returns nil if the transaction is neither stored nor on chain.
+func (relay *RelayServer) TransactionStatusByHash(ctx context.Context, hash common.Hash) (status *TransactionStatus, err error) {
+	timedTx, err := relay.TxStore.GetTransactionByHash(hash)
+	if err != nil {
+		return
+	}
+	if timedTx != nil {
+		status, err = relay.storedTransactionStatus(ctx, timedTx)
+		if err != nil {
+			return
+		}
+		if status.Hash != hash {
+			status.State = TxStateReplaced
+			status.ReplacedBy = &status.Hash
+		}
+		return
+	}
+
+	// Confirmed transactions are removed from the store, but can still be found on chain
+	tx, _, err := relay.Client.TransactionByHash(ctx, hash)
+	if err == ethereum.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+	chainID, err := relay.ChainID(ctx)
+	if err != nil {
+		return
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return
+	}
+	if sender != relay.Address() {
+		return nil, nil
+	}
+	status = &TransactionStatus{State: TxStatePending, Nonce: tx.Nonce(), Hash: hash, Attempts: []common.Hash{hash}}
+	receipt, err := relay.Client.TransactionReceipt(ctx, hash)
+	if err == ethereum.NotFound {
+		return status, nil
+	} else if err != nil {
+		return
+	}
+	err = relay.fillMinedStatus(ctx, status, receipt)
+	return
+}
+
+// TransactionStatusByNonce returns the status of a pending or unconfirmed transaction sent by the relay with the given
+// nonce. Returns nil if there is none.
+func (relay *RelayServer) TransactionStatusByNonce(ctx context.Context, nonce uint64) (status *TransactionStatus, err error) {
+	txs, err := relay.TxStore.ListTransactions()
+	if err != nil {
+		return
+	}
+	for _, tx := range txs {
+		if tx.Nonce() == nonce {
+			return relay.storedTransactionStatus(ctx, tx)
+		}
+	}
+	return nil, nil
+}
+
+func (relay *RelayServer) storedTransactionStatus(ctx context.Context, timedTx *txstore.TimestampedTransaction) (status *TransactionStatus, err error) {
+	status = &TransactionStatus{State: TxStatePending, Nonce: timedTx.Nonce(), Hash: timedTx.Hash()}
+	for _, attempt := range timedTx.Attempts {
+		status.Attempts = append(status.Attempts, attempt.Hash)
+	}
+
+	// Any attempt may have been mined, most likely the latest one
+	for i := len(status.Attempts) - 1; i >= 0; i-- {
+		receipt, err := relay.Client.TransactionReceipt(ctx, status.Attempts[i])
+		if err == ethereum.NotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		status.Hash = status.Attempts[i]
+		return status, relay.fillMinedStatus(ctx, status, receipt)
+	}
+
+	nonce, err := relay.Client.NonceAt(ctx, relay.Address(), nil)
+	if err != nil {
+		return
+	}
+	if nonce > timedTx.Nonce() {
+		status.State = TxStateDropped
+	}
+	return
+}
+
+func (relay *RelayServer) fillMinedStatus(ctx context.Context, status *TransactionStatus, receipt *types.Receipt) (err error) {
+	latest, err := relay.Client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return
+	}
+	status.BlockNumber = receipt.BlockNumber
+	if latest.Number.Cmp(receipt.BlockNumber) >= 0 {
+		status.Confirmations = new(big.Int).Sub(latest.Number, receipt.BlockNumber).Uint64() + 1
+	}
+	status.State = TxStateMined
+	if status.Confirmations >= confirmationsNeeded {
+		status.State = TxStateConfirmed
+	}
+	status.HubEvent = relay.hubEvent(receipt)
+	return
+}
+
+// hubEvent decodes the TransactionRelayed or CanRelayFailed event of a receipt, if any
+func (relay *RelayServer) hubEvent(receipt *types.Receipt) *HubEvent {
+	relayedID := relay.rhubABI.Events["TransactionRelayed"].ID
+	failedID := relay.rhubABI.Events["CanRelayFailed"].ID
+	for _, txLog := range receipt.Logs {
+		if txLog.Address != relay.RelayHubAddress || len(txLog.Topics) == 0 {
+			continue
+		}
+		switch txLog.Topics[0] {
+		case relayedID:
+			event, err := relay.rhub.ParseTransactionRelayed(*txLog)
+			if err != nil {
+				continue
+			}
+			status := "Unknown"
+			if int(event.Status) < len(relayCallStatusNames) {
+				status = relayCallStatusNames[event.Status]
+			}
+			return &HubEvent{Name: "TransactionRelayed", From: event.From, To: event.To, Paymaster: event.Paymaster, Status: status, Charge: event.Charge}
+		case failedID:
+			event, err := relay.rhub.ParseCanRelayFailed(*txLog)
+			if err != nil {
+				continue
+			}
+			return &HubEvent{Name: "CanRelayFailed", From: event.From, To: event.To, Paymaster: event.Paymaster, Reason: event.Reason}
+		}
+	}
+	return nil
+}
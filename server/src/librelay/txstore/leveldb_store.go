@@ -3,14 +3,18 @@ package txstore
 import (
 	"encoding/binary"
 	"fmt"
+	"math/big"
 	"sync"
 
 	"code.cloudfoundry.org/clock"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 type LevelDbTxStore struct {
@@ -19,27 +23,76 @@ type LevelDbTxStore struct {
 	mutex *sync.Mutex
 }
 
+// Versions of the encoding of stored transactions, given by their first byte. Version 0 is the 8 bytes big endian
+// timestamp followed by the RLP encoded transaction (timestamps fit in 7 bytes, so the first one is always 0).
+// Version 1 is followed by the RLP encoded storedTransaction, which includes the attempts.
+const (
+	txEncodingV0      = 0
+	txEncodingV1      = 1
+	txEncodingVersion = txEncodingV1
+)
+
+type storedTransaction struct {
+	Transaction *types.Transaction
+	Timestamp   uint64
+	Attempts    []storedAttempt
+	Unsent      bool `rlp:"optional"`
+}
+
+type storedAttempt struct {
+	Hash      common.Hash
+	GasPrice  *big.Int
+	Timestamp uint64
+	GasTipCap *big.Int `rlp:"optional"` // nil for legacy transactions
+}
+
 func (tx *TimestampedTransaction) Encode() ([]byte, error) {
-	bytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(bytes, uint64(tx.Timestamp))
-	txBytes, err := rlp.EncodeToBytes(tx.Transaction)
+	stored := storedTransaction{Transaction: tx.Transaction, Timestamp: uint64(tx.Timestamp), Unsent: tx.Unsent}
+	for _, attempt := range tx.Attempts {
+		stored.Attempts = append(stored.Attempts, storedAttempt{attempt.Hash, attempt.GasPrice, uint64(attempt.Timestamp), attempt.GasTipCap})
+	}
+	txBytes, err := rlp.EncodeToBytes(&stored)
 	if err != nil {
 		return nil, err
 	}
-	bytes = append(bytes, txBytes...)
-	return bytes, nil
+	return append([]byte{txEncodingVersion}, txBytes...), nil
 }
 
 func DecodeTimestampedTransaction(bytes []byte) (*TimestampedTransaction, error) {
-	var tx types.Transaction
-	err := rlp.DecodeBytes(bytes[8:], &tx)
-	if err != nil {
-		return nil, err
+	if len(bytes) == 0 {
+		return nil, fmt.Errorf("Empty stored transaction")
 	}
 
-	timestamp := int64(binary.BigEndian.Uint64(bytes[:8]))
-	timedtx := TimestampedTransaction{&tx, timestamp}
-	return &timedtx, nil
+	switch bytes[0] {
+	case txEncodingV0:
+		if len(bytes) < 8 {
+			return nil, fmt.Errorf("Stored transaction too short: %d bytes", len(bytes))
+		}
+		var tx types.Transaction
+		err := rlp.DecodeBytes(bytes[8:], &tx)
+		if err != nil {
+			return nil, err
+		}
+
+		timestamp := int64(binary.BigEndian.Uint64(bytes[:8]))
+		return NewTimestampedTransaction(&tx, timestamp), nil
+
+	case txEncodingV1:
+		var stored storedTransaction
+		err := rlp.DecodeBytes(bytes[1:], &stored)
+		if err != nil {
+			return nil, err
+		}
+
+		timedtx := &TimestampedTransaction{Transaction: stored.Transaction, Timestamp: int64(stored.Timestamp), Unsent: stored.Unsent}
+		for _, attempt := range stored.Attempts {
+			timedtx.Attempts = append(timedtx.Attempts, TransactionAttempt{attempt.Hash, attempt.GasPrice, attempt.GasTipCap, int64(attempt.Timestamp)})
+		}
+		return timedtx, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown stored transaction encoding version %d", bytes[0])
+	}
 }
 
 func NewLevelDbTxStore(file string, clk clock.Clock) (store *LevelDbTxStore, err error) {
@@ -52,7 +105,46 @@ func NewLevelDbTxStore(file string, clk clock.Clock) (store *LevelDbTxStore, err
 		return nil, err
 	}
 
-	return &LevelDbTxStore{db, clk, &sync.Mutex{}}, nil
+	store = &LevelDbTxStore{db, clk, &sync.Mutex{}}
+	err = store.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrate re-encodes the transactions stored with a previous encoding version
+func (store *LevelDbTxStore) migrate() (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	batch := new(leveldb.Batch)
+	iter := store.NewIterator(nil, nil)
+	for iter.Next() {
+		value := iter.Value()
+		if len(value) > 0 && value[0] == txEncodingVersion {
+			continue
+		}
+		tx, err := DecodeTimestampedTransaction(value)
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		txbytes, err := tx.Encode()
+		if err != nil {
+			iter.Release()
+			return err
+		}
+		batch.Put(append([]byte(nil), iter.Key()...), txbytes)
+	}
+	iter.Release()
+
+	if batch.Len() == 0 {
+		return
+	}
+	log.Info("TxStore: migrating transactions", "count", batch.Len(), "version", txEncodingVersion)
+	return store.Write(batch, nil)
 }
 
 // ListTransactions returns all transactions on the store, useful for testing
@@ -91,40 +183,120 @@ func (store *LevelDbTxStore) GetFirstTransaction() (tx *TimestampedTransaction,
 	return nil, nil
 }
 
+// GetTransactionByHash returns the transaction of which any attempt has the given hash, or nil if there is none
+func (store *LevelDbTxStore) GetTransactionByHash(hash common.Hash) (tx *TimestampedTransaction, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	iter := store.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		tx, err := DecodeTimestampedTransaction(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if tx.HasHash(hash) {
+			return tx, nil
+		}
+	}
+	return nil, nil
+}
+
 // SaveTransaction dates and stores transaction sorted by ascending nonce
 func (store *LevelDbTxStore) SaveTransaction(tx *types.Transaction) (err error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	timedtx := &TimestampedTransaction{tx, store.clock.Now().Unix()}
-	txbytes, err := timedtx.Encode()
+	return store.put(NewTimestampedTransaction(tx, store.clock.Now().Unix()), nil)
+}
+
+// SaveUnsentTransaction dates and stores a transaction about to be broadcast, syncing it to disk so it survives a
+// crash while it is being sent
+func (store *LevelDbTxStore) SaveUnsentTransaction(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	timedtx := NewTimestampedTransaction(tx, store.clock.Now().Unix())
+	timedtx.Unsent = true
+	return store.put(timedtx, &opt.WriteOptions{Sync: true})
+}
+
+// MarkTransactionSent records that a transaction stored as unsent was broadcast,
+// returns error if it is not the stored tx with its nonce
+func (store *LevelDbTxStore) MarkTransactionSent(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	stored, err := store.get(tx.Nonce())
 	if err != nil {
 		return err
 	}
+	if stored.Hash() != tx.Hash() {
+		return fmt.Errorf("Stored transaction with nonce %d is %s, not %s", tx.Nonce(), stored.Hash().Hex(), tx.Hash().Hex())
+	}
+	stored.Unsent = false
+	return store.put(stored, nil)
+}
 
-	key := make([]byte, 8)
-	binary.BigEndian.PutUint64(key, tx.Nonce())
-	err = store.Put(key, txbytes, nil)
+// RemoveTransactionByNonce removes the transaction with the given nonce, if any
+func (store *LevelDbTxStore) RemoveTransactionByNonce(nonce uint64) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.Delete(nonceKey(nonce), nil)
+}
+
+// UpdateTransactionByNonce updates a transaction given its nonce, keeping the previous one in its attempts,
+// returns error if tx with same nonce does not exist
+func (store *LevelDbTxStore) UpdateTransactionByNonce(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, err := store.get(tx.Nonce())
 	if err != nil {
 		return err
 	}
-
-	return
+	return store.put(previous.replacedBy(tx, store.clock.Now().Unix()), nil)
 }
 
-// UpdateTransactionByNonce updates a transaction given its nonce, returns error if tx with same nonce does not exist
-func (store *LevelDbTxStore) UpdateTransactionByNonce(tx *types.Transaction) (err error) {
-	key := make([]byte, 8)
-	binary.BigEndian.PutUint64(key, tx.Nonce())
+// UpdateUnsentTransactionByNonce stores a replacement about to be broadcast as UpdateTransactionByNonce does, as unsent,
+// syncing it to disk as SaveUnsentTransaction does
+func (store *LevelDbTxStore) UpdateUnsentTransactionByNonce(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, err := store.get(tx.Nonce())
+	if err != nil {
+		return err
+	}
+	timedtx := previous.replacedBy(tx, store.clock.Now().Unix())
+	timedtx.Unsent = true
+	return store.put(timedtx, &opt.WriteOptions{Sync: true})
+}
 
-	_, err = store.Get(key, nil)
+func (store *LevelDbTxStore) get(nonce uint64) (timedtx *TimestampedTransaction, err error) {
+	value, err := store.Get(nonceKey(nonce), nil)
 	if err == leveldb.ErrNotFound {
-		return fmt.Errorf("Could not find transaction with nonce %d", tx.Nonce())
+		return nil, fmt.Errorf("Could not find transaction with nonce %d", nonce)
 	} else if err != nil {
+		return nil, err
+	}
+	return DecodeTimestampedTransaction(value)
+}
+
+func (store *LevelDbTxStore) put(timedtx *TimestampedTransaction, wo *opt.WriteOptions) (err error) {
+	txbytes, err := timedtx.Encode()
+	if err != nil {
 		return err
 	}
 
-	return store.SaveTransaction(tx)
+	return store.Put(nonceKey(timedtx.Nonce()), txbytes, wo)
+}
+
+func nonceKey(nonce uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, nonce)
+	return key
 }
 
 // RemoveTransactionsLessThanNonce removes all transactions with nonce values up to the specified value inclusive
@@ -7,6 +7,7 @@ import (
 
 	"code.cloudfoundry.org/clock"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -48,31 +49,112 @@ func (store *MemoryTxStore) GetFirstTransaction() (tx *TimestampedTransaction, e
 	return front.Value.(*TimestampedTransaction), nil
 }
 
+// GetTransactionByHash returns the transaction of which any attempt has the given hash, or nil if there is none
+func (store *MemoryTxStore) GetTransactionByHash(hash common.Hash) (tx *TimestampedTransaction, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for e := store.transactions.Front(); e != nil; e = e.Next() {
+		if e.Value.(*TimestampedTransaction).HasHash(hash) {
+			return e.Value.(*TimestampedTransaction), nil
+		}
+	}
+	return nil, nil
+}
+
 // SaveTransaction dates and stores transaction sorted by ascending nonce
 func (store *MemoryTxStore) SaveTransaction(tx *types.Transaction) (err error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	timedtx := &TimestampedTransaction{tx, store.clock.Now().Unix()}
+	store.insert(NewTimestampedTransaction(tx, store.clock.Now().Unix()))
+	return
+}
+
+// SaveUnsentTransaction dates and stores a transaction about to be broadcast
+func (store *MemoryTxStore) SaveUnsentTransaction(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	timedtx := NewTimestampedTransaction(tx, store.clock.Now().Unix())
+	timedtx.Unsent = true
+	store.insert(timedtx)
+	return
+}
+
+func (store *MemoryTxStore) insert(timedtx *TimestampedTransaction) {
 	for e := store.transactions.Front(); e != nil; e = e.Next() {
-		if e.Value.(*TimestampedTransaction).Nonce() > tx.Nonce() {
+		if e.Value.(*TimestampedTransaction).Nonce() > timedtx.Nonce() {
 			store.transactions.InsertBefore(timedtx, e)
 			return
 		}
 	}
 
 	store.transactions.PushBack(timedtx)
+}
+
+// MarkTransactionSent records that a transaction stored as unsent was broadcast,
+// returns error if it is not the stored tx with its nonce
+func (store *MemoryTxStore) MarkTransactionSent(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for e := store.transactions.Front(); e != nil; e = e.Next() {
+		stored := e.Value.(*TimestampedTransaction)
+		if stored.Nonce() != tx.Nonce() {
+			continue
+		}
+		if stored.Hash() != tx.Hash() {
+			return fmt.Errorf("Stored transaction with nonce %d is %s, not %s", tx.Nonce(), stored.Hash().Hex(), tx.Hash().Hex())
+		}
+		sent := *stored
+		sent.Unsent = false
+		e.Value = &sent
+		return nil
+	}
+
+	return fmt.Errorf("Could not find transaction with nonce %d", tx.Nonce())
+}
+
+// RemoveTransactionByNonce removes the transaction with the given nonce, if any
+func (store *MemoryTxStore) RemoveTransactionByNonce(nonce uint64) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for e := store.transactions.Front(); e != nil; e = e.Next() {
+		if e.Value.(*TimestampedTransaction).Nonce() == nonce {
+			store.transactions.Remove(e)
+			return
+		}
+	}
 	return
 }
 
-// UpdateTransactionByNonce updates a transaction given its nonce, returns error if tx with same nonce does not exist
+// UpdateTransactionByNonce updates a transaction given its nonce, keeping the previous one in its attempts,
+// returns error if tx with same nonce does not exist
 func (store *MemoryTxStore) UpdateTransactionByNonce(tx *types.Transaction) (err error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	timedtx := &TimestampedTransaction{tx, store.clock.Now().Unix()}
 	for e := store.transactions.Front(); e != nil; e = e.Next() {
 		if e.Value.(*TimestampedTransaction).Nonce() == tx.Nonce() {
+			e.Value = e.Value.(*TimestampedTransaction).replacedBy(tx, store.clock.Now().Unix())
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Could not find transaction with nonce %d", tx.Nonce())
+}
+
+// UpdateUnsentTransactionByNonce stores a replacement about to be broadcast as UpdateTransactionByNonce does, as unsent
+func (store *MemoryTxStore) UpdateUnsentTransactionByNonce(tx *types.Transaction) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for e := store.transactions.Front(); e != nil; e = e.Next() {
+		if e.Value.(*TimestampedTransaction).Nonce() == tx.Nonce() {
+			timedtx := e.Value.(*TimestampedTransaction).replacedBy(tx, store.clock.Now().Unix())
+			timedtx.Unsent = true
 			e.Value = timedtx
 			return nil
 		}
@@ -0,0 +1,88 @@
+package txstore
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// ISignedNonceStore keeps the hash of the payload signed with each nonce, for as long as the relay key is used
+type ISignedNonceStore interface {
+	GetSignedPayload(nonce uint64) (payloadHash common.Hash, found bool, err error)
+	SaveSignedPayload(nonce uint64, payloadHash common.Hash) (err error)
+	RemoveSignedPayload(nonce uint64) (err error)
+	Close() (err error)
+}
+
+type MemorySignedNonceStore struct {
+	payloads map[uint64]common.Hash
+	mutex    *sync.Mutex
+}
+
+func NewMemorySignedNonceStore() *MemorySignedNonceStore {
+	return &MemorySignedNonceStore{payloads: map[uint64]common.Hash{}, mutex: &sync.Mutex{}}
+}
+
+func (store *MemorySignedNonceStore) GetSignedPayload(nonce uint64) (payloadHash common.Hash, found bool, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	payloadHash, found = store.payloads[nonce]
+	return
+}
+
+func (store *MemorySignedNonceStore) SaveSignedPayload(nonce uint64, payloadHash common.Hash) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.payloads[nonce] = payloadHash
+	return
+}
+
+func (store *MemorySignedNonceStore) RemoveSignedPayload(nonce uint64) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.payloads, nonce)
+	return
+}
+
+func (store *MemorySignedNonceStore) Close() (err error) {
+	return nil
+}
+
+// LevelDbSignedNonceStore keeps the payload hashes by nonce in their own database, as the TxStore forgets
+// confirmed transactions
+type LevelDbSignedNonceStore struct {
+	*leveldb.DB
+}
+
+func NewLevelDbSignedNonceStore(file string) (store *LevelDbSignedNonceStore, err error) {
+	db, err := leveldb.OpenFile(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDbSignedNonceStore{db}, nil
+}
+
+func (store *LevelDbSignedNonceStore) GetSignedPayload(nonce uint64) (payloadHash common.Hash, found bool, err error) {
+	value, err := store.Get(nonceKey(nonce), nil)
+	if err == leveldb.ErrNotFound {
+		return payloadHash, false, nil
+	} else if err != nil {
+		return
+	}
+	return common.BytesToHash(value), true, nil
+}
+
+// SaveSignedPayload stores the payload hash synced to disk, as it must outlive any crash once the tx is signed
+func (store *LevelDbSignedNonceStore) SaveSignedPayload(nonce uint64, payloadHash common.Hash) (err error) {
+	return store.Put(nonceKey(nonce), payloadHash.Bytes(), &opt.WriteOptions{Sync: true})
+}
+
+func (store *LevelDbSignedNonceStore) RemoveSignedPayload(nonce uint64) (err error) {
+	return store.Delete(nonceKey(nonce), nil)
+}
@@ -1,19 +1,67 @@
 package txstore
 
 import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
+// TimestampedTransaction is the latest transaction sent with a nonce, along with every attempt sent with that nonce
 type TimestampedTransaction struct {
 	*types.Transaction
+	Timestamp int64                // time the latest attempt was sent
+	Attempts  []TransactionAttempt // oldest first, the last one being the latest attempt
+	Unsent    bool                 // stored before broadcast, and not known to have reached the node yet
+}
+
+// TransactionAttempt is a transaction sent with a nonce, which may have been replaced since
+type TransactionAttempt struct {
+	Hash      common.Hash
+	GasPrice  *big.Int // gas price, or max fee per gas of EIP-1559 transactions
+	GasTipCap *big.Int // max priority fee per gas of EIP-1559 transactions, nil for legacy ones
 	Timestamp int64
 }
 
+func NewTimestampedTransaction(tx *types.Transaction, timestamp int64) *TimestampedTransaction {
+	return &TimestampedTransaction{Transaction: tx, Timestamp: timestamp, Attempts: []TransactionAttempt{newTransactionAttempt(tx, timestamp)}}
+}
+
+// replacedBy returns the record of a replacement of the transaction, keeping the previous attempts
+func (tx *TimestampedTransaction) replacedBy(newTx *types.Transaction, timestamp int64) *TimestampedTransaction {
+	attempts := make([]TransactionAttempt, len(tx.Attempts), len(tx.Attempts)+1)
+	copy(attempts, tx.Attempts)
+	return &TimestampedTransaction{Transaction: newTx, Timestamp: timestamp, Attempts: append(attempts, newTransactionAttempt(newTx, timestamp))}
+}
+
+// HasHash returns whether any attempt sent with the transaction's nonce has the given hash
+func (tx *TimestampedTransaction) HasHash(hash common.Hash) bool {
+	for _, attempt := range tx.Attempts {
+		if attempt.Hash == hash {
+			return true
+		}
+	}
+	return tx.Hash() == hash
+}
+
+func newTransactionAttempt(tx *types.Transaction, timestamp int64) TransactionAttempt {
+	attempt := TransactionAttempt{Hash: tx.Hash(), GasPrice: tx.GasPrice(), Timestamp: timestamp}
+	if tx.Type() == types.DynamicFeeTxType {
+		attempt.GasTipCap = tx.GasTipCap()
+	}
+	return attempt
+}
+
 type ITxStore interface {
 	ListTransactions() (txs []*TimestampedTransaction, err error)
 	GetFirstTransaction() (tx *TimestampedTransaction, err error)
+	GetTransactionByHash(hash common.Hash) (tx *TimestampedTransaction, err error)
 	SaveTransaction(tx *types.Transaction) (err error)
+	SaveUnsentTransaction(tx *types.Transaction) (err error)
+	MarkTransactionSent(tx *types.Transaction) (err error)
+	RemoveTransactionByNonce(nonce uint64) (err error)
 	UpdateTransactionByNonce(tx *types.Transaction) (err error)
+	UpdateUnsentTransactionByNonce(tx *types.Transaction) (err error)
 	RemoveTransactionsLessThanNonce(nonce uint64) (err error)
 	Clear() (err error)
 	Close() (err error)
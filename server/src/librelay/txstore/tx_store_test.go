@@ -1,6 +1,7 @@
 package txstore
 
 import (
+	"encoding/binary"
 	"math/big"
 	"math/rand"
 	"os"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func newTx(nonce uint64) (tx *types.Transaction) {
@@ -76,6 +78,48 @@ func testStore(t *testing.T, store ITxStore, clk *fakeclock.FakeClock) {
 		}
 	})
 
+	t.Run("UpdateTransactionByNonce keeps previous attempts", func(t *testing.T) {
+		store.Clear()
+		originalTx := newTx(4)
+		test.ErrFail(store.SaveTransaction(originalTx), t)
+		clk.IncrementBySeconds(60)
+		updatedTx := newTx(4)
+		test.ErrFail(store.UpdateTransactionByNonce(updatedTx), t)
+
+		tx, err := store.GetFirstTransaction()
+		test.ErrFail(err, t)
+		if len(tx.Attempts) != 2 || tx.Attempts[0].Hash != originalTx.Hash() || tx.Attempts[1].Hash != updatedTx.Hash() {
+			t.Fatalf("Wrong attempts after update: %v", tx.Attempts)
+		}
+		if tx.Attempts[1].Timestamp-tx.Attempts[0].Timestamp != 60 || tx.Timestamp != tx.Attempts[1].Timestamp {
+			t.Errorf("Wrong attempt timestamps %v and %v, tx timestamp %v", tx.Attempts[0].Timestamp, tx.Attempts[1].Timestamp, tx.Timestamp)
+		}
+		if tx.Attempts[0].GasPrice.Cmp(originalTx.GasPrice()) != 0 {
+			t.Errorf("Wrong attempt gas price %v, expected %v", tx.Attempts[0].GasPrice, originalTx.GasPrice())
+		}
+	})
+
+	t.Run("GetTransactionByHash finds replaced txs", func(t *testing.T) {
+		store.Clear()
+		originalTx := newTx(4)
+		updatedTx := newTx(4)
+		test.ErrFail(store.SaveTransaction(newTx(3)), t)
+		test.ErrFail(store.SaveTransaction(originalTx), t)
+		test.ErrFail(store.UpdateTransactionByNonce(updatedTx), t)
+
+		for _, hash := range []common.Hash{originalTx.Hash(), updatedTx.Hash()} {
+			tx, err := store.GetTransactionByHash(hash)
+			test.ErrFail(err, t)
+			if tx == nil || tx.Hash() != updatedTx.Hash() {
+				t.Errorf("Expected to find tx %v by hash %v but got %v", updatedTx.Hash().Hex(), hash.Hex(), tx)
+			}
+		}
+		tx, err := store.GetTransactionByHash(newTx(5).Hash())
+		if tx != nil || err != nil {
+			t.Errorf("Expected no tx but got %v (error %v)", tx, err)
+		}
+	})
+
 	t.Run("UpdateTransactionByNonce fails if tx is not present", func(t *testing.T) {
 		store.Clear()
 		test.ErrFail(store.SaveTransaction(newTx(3)), t)
@@ -85,6 +129,64 @@ func testStore(t *testing.T, store ITxStore, clk *fakeclock.FakeClock) {
 		}
 	})
 
+	t.Run("SaveUnsentTransaction stores txs as unsent until MarkTransactionSent", func(t *testing.T) {
+		store.Clear()
+		unsentTx := newTx(4)
+		test.ErrFail(store.SaveTransaction(newTx(3)), t)
+		test.ErrFail(store.SaveUnsentTransaction(unsentTx), t)
+
+		txs, err := store.ListTransactions()
+		test.ErrFail(err, t)
+		if len(txs) != 2 || txs[0].Unsent || !txs[1].Unsent || txs[1].Hash() != unsentTx.Hash() {
+			t.Fatalf("Wrong transactions after saving unsent tx: %v", txs)
+		}
+
+		if store.MarkTransactionSent(newTx(4)) == nil {
+			t.Errorf("Expected error marking sent a tx other than the stored one")
+		}
+		test.ErrFail(store.MarkTransactionSent(unsentTx), t)
+		tx, err := store.GetTransactionByHash(unsentTx.Hash())
+		test.ErrFail(err, t)
+		if tx == nil || tx.Unsent {
+			t.Errorf("Expected tx to be marked sent but got %v", tx)
+		}
+		if store.MarkTransactionSent(newTx(5)) == nil {
+			t.Errorf("Expected error marking sent a tx that is not present")
+		}
+	})
+
+	t.Run("UpdateUnsentTransactionByNonce stores the replacement as unsent", func(t *testing.T) {
+		store.Clear()
+		originalTx, updatedTx := newTx(4), newTx(4)
+		test.ErrFail(store.SaveTransaction(originalTx), t)
+		test.ErrFail(store.UpdateUnsentTransactionByNonce(updatedTx), t)
+
+		tx, err := store.GetFirstTransaction()
+		test.ErrFail(err, t)
+		if tx.Hash() != updatedTx.Hash() || !tx.Unsent || len(tx.Attempts) != 2 || tx.Attempts[0].Hash != originalTx.Hash() {
+			t.Fatalf("Wrong transaction after unsent update: %v", tx)
+		}
+		test.ErrFail(store.MarkTransactionSent(updatedTx), t)
+		if store.UpdateUnsentTransactionByNonce(newTx(5)) == nil {
+			t.Errorf("Expected error updating a tx that is not present")
+		}
+	})
+
+	t.Run("RemoveTransactionByNonce removes only that tx", func(t *testing.T) {
+		store.Clear()
+		test.ErrFail(store.SaveTransaction(newTx(3)), t)
+		test.ErrFail(store.SaveUnsentTransaction(newTx(4)), t)
+		test.ErrFail(store.SaveTransaction(newTx(5)), t)
+		test.ErrFail(store.RemoveTransactionByNonce(4), t)
+		test.ErrFail(store.RemoveTransactionByNonce(6), t)
+
+		txs, err := store.ListTransactions()
+		test.ErrFail(err, t)
+		if len(txs) != 2 || txs[0].Nonce() != 3 || txs[1].Nonce() != 5 {
+			t.Errorf("Transactions left after removal: %v", txs)
+		}
+	})
+
 	t.Run("RemoveTransactionsLessThanNonce removes transactions strictly less than parameter", func(t *testing.T) {
 		store.Clear()
 		test.ErrFail(store.SaveTransaction(newTx(4)), t)
@@ -119,7 +221,7 @@ func TestLevelDbStore(t *testing.T) {
 
 func TestTransactionEncode(t *testing.T) {
 	timestamp := time.Now().Unix()
-	tx := TimestampedTransaction{newTx(10), timestamp}
+	tx := NewTimestampedTransaction(newTx(10), timestamp)
 	bytes, err := tx.Encode()
 	test.ErrFailWithDesc(err, t, "Error encoding transaction")
 	decodedTx, err := DecodeTimestampedTransaction(bytes)
@@ -137,6 +239,70 @@ func TestTransactionEncode(t *testing.T) {
 	if decodedTx.To().Hex() != tx.To().Hex() {
 		t.Errorf("Incorrect recipient %v, expected %v", decodedTx.To().Hex(), tx.To().Hex())
 	}
+	if len(decodedTx.Attempts) != 1 || decodedTx.Attempts[0].Hash != tx.Hash() || decodedTx.Attempts[0].Timestamp != tx.Timestamp {
+		t.Errorf("Incorrect attempts %v, expected %v", decodedTx.Attempts, tx.Attempts)
+	}
+	if decodedTx.Unsent {
+		t.Errorf("Expected tx not to be unsent")
+	}
+
+	tx.Unsent = true
+	bytes, err = tx.Encode()
+	test.ErrFailWithDesc(err, t, "Error encoding unsent transaction")
+	decodedTx, err = DecodeTimestampedTransaction(bytes)
+	test.ErrFailWithDesc(err, t, "Error decoding unsent transaction")
+	if !decodedTx.Unsent {
+		t.Errorf("Expected tx to be unsent")
+	}
+}
+
+func TestDynamicFeeTransactionEncode(t *testing.T) {
+	address := common.HexToAddress("ffcf8fdee72ac11b5c542428b35eef5769c409f0")
+	dynamicTx := types.NewTx(&types.DynamicFeeTx{
+		ChainID: big.NewInt(1), Nonce: 10, GasTipCap: big.NewInt(2), GasFeeCap: big.NewInt(3000), Gas: 21000, To: &address, Value: big.NewInt(10),
+	})
+	tx := NewTimestampedTransaction(newTx(10), time.Now().Unix()).replacedBy(dynamicTx, time.Now().Unix())
+	bytes, err := tx.Encode()
+	test.ErrFailWithDesc(err, t, "Error encoding transaction")
+	decodedTx, err := DecodeTimestampedTransaction(bytes)
+	test.ErrFailWithDesc(err, t, "Error decoding transaction")
+
+	if decodedTx.Hash() != dynamicTx.Hash() || decodedTx.Type() != types.DynamicFeeTxType {
+		t.Errorf("Incorrect tx %v, expected %v", decodedTx.Hash().Hex(), dynamicTx.Hash().Hex())
+	}
+	if len(decodedTx.Attempts) != 2 || decodedTx.Attempts[0].GasTipCap != nil || decodedTx.Attempts[1].GasTipCap.Cmp(big.NewInt(2)) != 0 ||
+		decodedTx.Attempts[1].GasPrice.Cmp(big.NewInt(3000)) != 0 {
+		t.Errorf("Incorrect attempts %v", decodedTx.Attempts)
+	}
+}
+
+func TestLevelDbStoreMigratesOnOpen(t *testing.T) {
+	os.RemoveAll("test.db")
+	store, err := NewLevelDbTxStore("test.db", nil)
+	test.ErrFail(err, t)
+
+	// Store a tx with the version 0 encoding: the timestamp followed by the tx
+	oldTx := newTx(3)
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, 1234)
+	txBytes, err := rlp.EncodeToBytes(oldTx)
+	test.ErrFail(err, t)
+	test.ErrFail(store.Put(nonceKey(3), append(value, txBytes...), nil), t)
+	test.ErrFail(store.Close(), t)
+
+	store, err = NewLevelDbTxStore("test.db", nil)
+	defer cleanupDb(store)
+	test.ErrFail(err, t)
+	migrated, err := store.Get(nonceKey(3), nil)
+	test.ErrFail(err, t)
+	if migrated[0] != txEncodingVersion {
+		t.Errorf("Expected tx to be migrated to encoding version %v but got %v", txEncodingVersion, migrated[0])
+	}
+	tx, err := store.GetTransactionByHash(oldTx.Hash())
+	test.ErrFail(err, t)
+	if tx == nil || tx.Timestamp != 1234 || len(tx.Attempts) != 1 || tx.Attempts[0].Hash != oldTx.Hash() {
+		t.Errorf("Incorrect migrated tx %v", tx)
+	}
 }
 
 func cleanupDb(store *LevelDbTxStore) {
@@ -0,0 +1,151 @@
+package librelay
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// UnsentRecoveryTimeout is the usual bound of the time spent at startup recovering the transactions left unsent by a
+// crash, with RecoverUnsentTransactions
+const UnsentRecoveryTimeout = 30 * time.Second
+
+// Errors of nodes refusing a transaction outright: it can never be mined, so its nonce is free to be used again.
+// They are only known by their messages over RPC.
+var definitiveRejections = []error{core.ErrNonceTooLow, core.ErrInsufficientFunds, core.ErrIntrinsicGas}
+
+// isDefinitiveRejection returns whether err is one of the definitiveRejections
+func isDefinitiveRejection(err error) bool {
+	for _, rejection := range definitiveRejections {
+		if strings.Contains(err.Error(), rejection.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastTransaction stores signedTx as unsent before sending it, so the relay keeps track of it even if it stops
+// right after the broadcast, and marks it sent afterwards. Returns whether signedTx was stored: if not, it never left
+// the relay, so the SigningGuard forgets it and its nonce may be released. If sending fails, the node may still have
+// received it and its nonce cannot be signed with another payload, so it stays stored as unsent, to be resent by
+// UpdateUnconfirmedTransactions, unless the node rejected it definitively: then it is removed and forgotten as if it
+// had never been stored.
+func (relay *RelayServer) broadcastTransaction(ctx context.Context, logger Logger, desc string, signedTx *types.Transaction) (stored bool, err error) {
+	err = relay.TxStore.SaveUnsentTransaction(signedTx)
+	if err != nil {
+		logger.Error("Error saving transaction", "desc", desc, "nonce", signedTx.Nonce(), "txHash", signedTx.Hash(), "err", err)
+		relay.discardTransaction(logger, desc, signedTx)
+		return
+	}
+
+	err = relay.sendStoredTransaction(ctx, logger, desc, signedTx)
+	if err == nil || !isDefinitiveRejection(err) {
+		return true, err
+	}
+	if removeErr := relay.TxStore.RemoveTransactionByNonce(signedTx.Nonce()); removeErr != nil {
+		// Left stored as unsent, it is resent and rejected again by RecoverUnsentTransactions
+		logger.Error("Error removing rejected transaction", "desc", desc, "nonce", signedTx.Nonce(), "txHash", signedTx.Hash(), "err", removeErr)
+		return true, err
+	}
+	relay.updatePendingTransactionsGauge()
+	relay.discardTransaction(logger, desc, signedTx)
+	return false, err
+}
+
+// discardTransaction makes the SigningGuard forget a signed transaction that never left the relay
+func (relay *RelayServer) discardTransaction(logger Logger, desc string, signedTx *types.Transaction) {
+	if discardErr := relay.SigningGuard.Discard(signedTx); discardErr != nil {
+		logger.Error("Error discarding signed transaction", "desc", desc, "nonce", signedTx.Nonce(), "err", discardErr)
+	}
+}
+
+// broadcastReplacement stores signedTx as unsent in place of the stored transaction with its nonce, as
+// broadcastTransaction does, before sending it. The SigningGuard keeps its payload even if it is not stored, as the
+// transaction it replaces was sent with the same one.
+func (relay *RelayServer) broadcastReplacement(ctx context.Context, logger Logger, desc string, signedTx *types.Transaction) (err error) {
+	err = relay.TxStore.UpdateUnsentTransactionByNonce(signedTx)
+	if err != nil {
+		logger.Error("Error saving transaction", "desc", desc, "nonce", signedTx.Nonce(), "txHash", signedTx.Hash(), "err", err)
+		return
+	}
+	return relay.sendStoredTransaction(ctx, logger, desc, signedTx)
+}
+
+// sendStoredTransaction sends a transaction stored as unsent, and marks it sent
+func (relay *RelayServer) sendStoredTransaction(ctx context.Context, logger Logger, desc string, signedTx *types.Transaction) (err error) {
+	relay.updatePendingTransactionsGauge()
+
+	err = relay.Client.SendTransaction(ctx, signedTx)
+	if err != nil {
+		logger.Error("Error sending transaction", "desc", desc, "nonce", signedTx.Nonce(), "txHash", signedTx.Hash(), "err", err)
+		return
+	}
+
+	// The tx is broadcast and stored either way, so only recovery on the next start is affected
+	if markErr := relay.TxStore.MarkTransactionSent(signedTx); markErr != nil {
+		logger.Error("Error marking transaction sent", "desc", desc, "nonce", signedTx.Nonce(), "txHash", signedTx.Hash(), "err", markErr)
+	}
+	return
+}
+
+// RecoverUnsentTransactions handles the transactions stored before broadcast but never marked sent, as left behind
+// when the relay stops while sending them. Those whose nonce was used on chain, or which the node already knows,
+// are marked sent. The others are broadcast again. A failure with one transaction does not keep the others from being
+// recovered: all the failures are returned together. It is meant to run once at startup, before relaying requests.
+func (relay *RelayServer) RecoverUnsentTransactions(ctx context.Context) (err error) {
+	txs, err := relay.TxStore.ListTransactions()
+	if err != nil {
+		return
+	}
+
+	var unsent []*types.Transaction
+	for _, tx := range txs {
+		if tx.Unsent {
+			unsent = append(unsent, tx.Transaction)
+		}
+	}
+	if len(unsent) == 0 {
+		return
+	}
+	relay.Logger.Warn("Recovering unsent transactions", "count", len(unsent))
+
+	accountNonce, err := relay.Client.NonceAt(ctx, relay.Address(), nil)
+	if err != nil {
+		return
+	}
+
+	var failures []string
+	for _, tx := range unsent {
+		logger := relay.Logger.New("nonce", tx.Nonce(), "txHash", tx.Hash())
+		if err = relay.recoverUnsentTransaction(ctx, logger, tx, accountNonce); err != nil {
+			// Left unsent, it is resent as a stuck transaction by UpdateUnconfirmedTransactions
+			logger.Error("Error recovering unsent transaction", "err", err)
+			failures = append(failures, fmt.Sprintf("nonce %d: %v", tx.Nonce(), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("could not recover %d of %d unsent transactions: %s", len(failures), len(unsent), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// recoverUnsentTransaction marks tx sent, broadcasting it first unless its nonce was used or the node knows it
+func (relay *RelayServer) recoverUnsentTransaction(ctx context.Context, logger Logger, tx *types.Transaction, accountNonce uint64) (err error) {
+	if tx.Nonce() < accountNonce {
+		logger.Info("Unsent transaction nonce already used on chain", "accountNonce", accountNonce)
+	} else if _, _, err = relay.Client.TransactionByHash(ctx, tx.Hash()); err == nil {
+		logger.Info("Unsent transaction already known to the node")
+	} else if err != ethereum.NotFound {
+		return
+	} else if err = relay.Client.SendTransaction(ctx, tx); err != nil {
+		return
+	} else {
+		logger.Info("Broadcast unsent transaction")
+	}
+	return relay.TxStore.MarkTransactionSent(tx)
+}
@@ -0,0 +1,149 @@
+package librelay
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"openeth.dev/librelay/test"
+	"openeth.dev/librelay/txstore"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// broadcastClient is an IClient recording the transactions sent, which knows the transactions in known
+type broadcastClient struct {
+	IClient
+	accountNonce uint64
+	known        map[common.Hash]bool
+	sendErr      error
+	rejected     map[common.Hash]error // send errors of given transactions
+	sent         []*types.Transaction
+}
+
+func (client *broadcastClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return client.accountNonce, nil
+}
+
+func (client *broadcastClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	if client.known[hash] {
+		return nil, true, nil
+	}
+	return nil, false, ethereum.NotFound
+}
+
+func (client *broadcastClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if client.sendErr != nil {
+		return client.sendErr
+	}
+	if err := client.rejected[tx.Hash()]; err != nil {
+		return err
+	}
+	client.sent = append(client.sent, tx)
+	return nil
+}
+
+func newBroadcastRelay(t *testing.T, client *broadcastClient) *RelayServer {
+	key, err := crypto.GenerateKey()
+	test.ErrFail(err, t)
+	return &RelayServer{Signer: NewLocalSigner(key), Client: client, TxStore: txstore.NewMemoryTxStore(nil), Logger: log.Root(), gapsMutex: &sync.Mutex{},
+		SigningGuard: NewSigningGuard(txstore.NewMemorySignedNonceStore(), false)}
+}
+
+func TestBroadcastTransaction(t *testing.T) {
+	client := &broadcastClient{}
+	relay := newBroadcastRelay(t, client)
+
+	tx := newNonceTx(3)
+	stored, err := relay.broadcastTransaction(context.Background(), relay.Logger, "test", tx)
+	test.ErrFail(err, t)
+	storedTx, err := relay.TxStore.GetTransactionByHash(tx.Hash())
+	test.ErrFail(err, t)
+	if !stored || len(client.sent) != 1 || storedTx == nil || storedTx.Unsent {
+		t.Errorf("Expected tx to be sent and stored as sent, got %v sent and stored %v", len(client.sent), storedTx)
+	}
+
+	// A tx the node may have received is kept, to be resent
+	client.sendErr = errors.New("unreachable")
+	unsent := newNonceTx(4)
+	stored, err = relay.broadcastTransaction(context.Background(), relay.Logger, "test", unsent)
+	if !stored || err == nil {
+		t.Fatalf("Expected send error after storing tx, got stored %v and error %v", stored, err)
+	}
+	storedTx, err = relay.TxStore.GetTransactionByHash(unsent.Hash())
+	test.ErrFail(err, t)
+	if storedTx == nil || !storedTx.Unsent {
+		t.Errorf("Expected tx 4 to stay unsent, got %v", storedTx)
+	}
+}
+
+func TestBroadcastTransactionRejected(t *testing.T) {
+	// Nodes report rejections as plain messages over RPC
+	client := &broadcastClient{sendErr: errors.New("insufficient funds for gas * price + value")}
+	relay := newBroadcastRelay(t, client)
+	sign := func(tx *types.Transaction) (*types.Transaction, error) { return tx, nil }
+
+	tx, err := relay.SigningGuard.Sign(newNonceTx(3), sign)
+	test.ErrFail(err, t)
+	stored, err := relay.broadcastTransaction(context.Background(), relay.Logger, "test", tx)
+	if stored || err == nil {
+		t.Fatalf("Expected the rejected tx not to be kept, got stored %v and error %v", stored, err)
+	}
+	storedTx, err := relay.TxStore.GetTransactionByHash(tx.Hash())
+	test.ErrFail(err, t)
+	if storedTx != nil {
+		t.Errorf("Expected the rejected tx to be removed, got %v", storedTx)
+	}
+	// Its nonce may be signed again with another payload
+	other := types.NewTransaction(3, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1), nil)
+	if _, err = relay.SigningGuard.Sign(other, sign); err != nil {
+		t.Errorf("Expected the rejected tx to be discarded, got %v", err)
+	}
+}
+
+func TestRecoverUnsentTransactions(t *testing.T) {
+	mined, known, lost, sent := newNonceTx(2), newNonceTx(3), newNonceTx(4), newNonceTx(5)
+	client := &broadcastClient{accountNonce: 3, known: map[common.Hash]bool{known.Hash(): true}}
+	relay := newBroadcastRelay(t, client)
+	for _, tx := range []*types.Transaction{mined, known, lost} {
+		test.ErrFail(relay.TxStore.SaveUnsentTransaction(tx), t)
+	}
+	test.ErrFail(relay.TxStore.SaveTransaction(sent), t)
+
+	test.ErrFail(relay.RecoverUnsentTransactions(context.Background()), t)
+	if len(client.sent) != 1 || client.sent[0].Hash() != lost.Hash() {
+		t.Errorf("Expected only tx 4 to be broadcast again, got %v", client.sent)
+	}
+	txs, err := relay.TxStore.ListTransactions()
+	test.ErrFail(err, t)
+	for _, tx := range txs {
+		if tx.Unsent {
+			t.Errorf("Expected tx %v to be marked sent", tx.Nonce())
+		}
+	}
+
+	// A broadcast failure leaves the tx unsent, without keeping the later ones from being recovered
+	unsent, later := newNonceTx(6), newNonceTx(7)
+	client.rejected = map[common.Hash]error{unsent.Hash(): errors.New("unreachable")}
+	test.ErrFail(relay.TxStore.SaveUnsentTransaction(unsent), t)
+	test.ErrFail(relay.TxStore.SaveUnsentTransaction(later), t)
+	if err = relay.RecoverUnsentTransactions(context.Background()); err == nil || !strings.Contains(err.Error(), "nonce 6") {
+		t.Errorf("Expected broadcast error of tx 6, got %v", err)
+	}
+	stored, err := relay.TxStore.GetTransactionByHash(unsent.Hash())
+	test.ErrFail(err, t)
+	if stored == nil || !stored.Unsent {
+		t.Errorf("Expected tx 6 to stay unsent, got %v", stored)
+	}
+	stored, err = relay.TxStore.GetTransactionByHash(later.Hash())
+	test.ErrFail(err, t)
+	if stored == nil || stored.Unsent || client.sent[len(client.sent)-1].Hash() != later.Hash() {
+		t.Errorf("Expected tx 7 to be broadcast and marked sent, got %v", stored)
+	}
+}
@@ -1,26 +1,36 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io/ioutil"
 	"openeth.dev/librelay"
 	"openeth.dev/librelay/txstore"
-	"log"
 	"math/big"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const VERSION = "0.4.2"
 
+// RequestIDHeader carries the ID correlating the log lines of a relay request. Clients may set it, otherwise one is
+// generated, and it is echoed in the response.
+const RequestIDHeader = "X-Request-Id"
+
 const SECONDS_PER_BLOCK = 12
 const REGISTRATION_BLOCK_RATE = 24*3600*30 / SECONDS_PER_BLOCK
 
@@ -29,9 +39,13 @@ var devMode bool                                                  // Whether we
 
 var ready = false
 var removed = false
+var reconciled = false // Whether the TxStore was reconciled with the chain since startup, see refreshBlockchainView
 
 var relay librelay.IRelay
 var server *http.Server
+// jobsContext is done when the background jobs must stop
+var jobsContext context.Context
+var cancelJobs context.CancelFunc
 var stopKeepAlive chan bool
 var stopRefreshBlockchainView chan bool
 var stopUpdatingPendingTxs chan bool
@@ -39,33 +53,57 @@ var stopListeningToRelayRemoved chan bool
 
 var timeUnit time.Duration
 
+// requestTimeout bounds the time spent handling a request, including the chain calls it makes
+var requestTimeout time.Duration
+
 var minimumRelayBalance = big.NewInt(1e17) // 0.1 eth
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	log.Println("RelayHttpServer starting. version:", VERSION)
+	relayParams := parseCommandLine()
+	if runCommand(relayParams) {
+		return
+	}
+	log.Info("RelayHttpServer starting", "version", VERSION)
 
-	configRelay(parseCommandLine())
+	configRelay(relayParams)
+	if penalizeRelays {
+		configPenalizationWatcher(relayParams)
+	}
 
 	server = &http.Server{Addr: ":" + relay.GetPort(), Handler: nil}
 
 	http.HandleFunc("/relay", assureRelayReady(relayHandler))
 	http.HandleFunc("/getaddr", getEthAddrHandler)
+	http.HandleFunc("/tx/", txStatusHandler)
+	if penalizeRelays {
+		http.HandleFunc("/report", reportHandler)
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
 
 	timeUnit = time.Minute
 	if devMode {
 		timeUnit = time.Second
 	}
-	stopKeepAlive = schedule(keepAlive, 10*timeUnit, 0)
-	stopRefreshBlockchainView = schedule(refreshBlockchainView, 1*timeUnit, 0)
-	stopUpdatingPendingTxs = schedule(updatePendingTxs, 1*timeUnit, 0)
-	stopListeningToRelayRemoved = schedule(stopServingOnRelayRemoved, 1*timeUnit, 0)
+	jobsContext, cancelJobs = context.WithCancel(context.Background())
+	stopKeepAlive = schedule(jobsContext, keepAlive, 10*timeUnit, 0)
+	stopRefreshBlockchainView = schedule(jobsContext, refreshBlockchainView, 1*timeUnit, 0)
+	stopUpdatingPendingTxs = schedule(jobsContext, updatePendingTxs, 1*timeUnit, 0)
+	stopListeningToRelayRemoved = schedule(jobsContext, stopServingOnRelayRemoved, 1*timeUnit, 0)
+	if penalizeRelays {
+		stopWatchingOffenders = schedule(jobsContext, watchOffenders, 1*timeUnit, 0)
+	}
+
+	handleShutdownSignals()
 
-	log.Println("RelayHttpServer started. Listening on port: ", relay.GetPort())
+	log.Info("RelayHttpServer started", "port", relay.GetPort())
 	err := server.ListenAndServe()
-	if err != nil {
-		log.Fatalln(err)
+	if err != http.ErrServerClosed {
+		log.Crit("RelayHttpServer stopped", "err", err)
 	}
+	<-shutdownComplete
+	log.Info("RelayHttpServer stopped")
 
 }
 
@@ -76,37 +114,54 @@ func assureRelayReady(fn http.HandlerFunc) http.HandlerFunc {
 		w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
 		w.Header()["Access-Control-Allow-Headers"] = []string{"Content-Type, Authorization, Content-Length, X-Requested-With"}
 		w.Header()["Access-Control-Allow-Methods"] = []string{"GET, POST, OPTIONS"}
+		logger := log.New("requestId", requestID(w, r))
+		ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		if !beginRelay() {
+			err := librelay.NewRelayError(librelay.ErrNotReady, "Relay shutting down")
+			logger.Warn("Relay request rejected", "err", err)
+			countRelayRequest(err)
+			writeError(w, err)
+			return
+		}
+		defer endRelay()
 
 		if !shouldHandleRelayRequests() {
-			err := fmt.Errorf("Relay not staked and registered yet")
-			log.Println(err)
-			w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+			err := librelay.NewRelayError(librelay.ErrNotReady, "Relay not staked and registered yet")
+			logger.Warn("Relay request rejected", "err", err)
+			countRelayRequest(err)
+			writeError(w, err)
 			return
 		}
 
 		// wait for funding
-		balance, err := relay.Balance()
+		balance, err := relay.Balance(ctx)
 		if err != nil {
-			log.Println(err)
-			w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+			logger.Error("Error getting relay balance", "err", err)
+			countRelayRequest(err)
+			writeError(w, err)
 			return
 		}
 		if balance.Cmp(big.NewInt(0)) == 0 {
-			err = fmt.Errorf("Waiting for funding...")
-			log.Println(err)
-			w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+			err = librelay.NewRelayError(librelay.ErrNotReady, "Waiting for funding...")
+			logger.Warn("Relay request rejected", "err", err)
+			countRelayRequest(err)
+			writeError(w, err)
 			return
 		}
-		log.Println("Relay balance:", balance.String())
+		logger.Debug("Relay balance", "balance", balance)
 
 		gasPrice := relay.GasPrice()
 		if gasPrice.Uint64() == 0 {
-			err = fmt.Errorf("Waiting for gasPrice...")
-			log.Println(err)
-			w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+			err = librelay.NewRelayError(librelay.ErrNotReady, "Waiting for gasPrice...")
+			logger.Warn("Relay request rejected", "err", err)
+			countRelayRequest(err)
+			writeError(w, err)
 			return
 		}
-		log.Println("Relay received gasPrice:", gasPrice.Uint64())
+		logger.Debug("Relay gas price", "gasPrice", &gasPrice)
 		fn(w, r)
 	}
 
@@ -124,20 +179,27 @@ func getEthAddrHandler(w http.ResponseWriter, _ *http.Request) {
 		Ready:              shouldHandleRelayRequests(),
 		Version:            VERSION,
 	}
+	if fees := relay.DynamicFees(); fees != nil {
+		getEthAddrResponse.BaseFeePerGas = *fees.BaseFee
+		getEthAddrResponse.MaxFeePerGas = *fees.MaxFeePerGas
+		getEthAddrResponse.MaxPriorityFeePerGas = *fees.MaxPriorityFeePerGas
+	}
 	resp, err := json.Marshal(getEthAddrResponse)
 	if err != nil {
-		log.Println(err)
-		w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+		log.Error("Error encoding getaddr response", "err", err)
+		writeError(w, err)
 		return
 	}
-	log.Printf("address %s sent\n", relay.Address().Hex())
+	log.Debug("Address sent", "address", relay.Address())
 
 	w.Write(resp)
 }
 
 func relayHandler(w http.ResponseWriter, r *http.Request) {
 
-	log.Println("Handling relay request...")
+	id := requestID(w, r)
+	logger := log.New("requestId", id)
+	logger.Info("Handling relay request")
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -145,33 +207,114 @@ func relayHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 
 	if err != nil {
-		log.Println("Could not read request body", body, err)
-		w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+		logger.Warn("Could not read request body", "err", err)
+		err = librelay.NewRelayError(librelay.ErrInvalidRequest, "%s", err)
+		countRelayRequest(err)
+		writeError(w, err)
 		return
 	}
 	var request = &librelay.RelayTransactionRequest{}
 	err = json.Unmarshal(body, request)
 	if err != nil {
-		log.Println("Invalid json", body, err)
-		w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+		logger.Warn("Invalid json", "body", string(body), "err", err)
+		err = librelay.NewRelayError(librelay.ErrInvalidRequest, "%s", err)
+		countRelayRequest(err)
+		writeError(w, err)
 		return
 	}
-	signedTx, err := relay.CreateRelayTransaction(*request)
+	signedTx, err := relay.CreateRelayTransaction(r.Context(), *request, id)
 	if err != nil {
-		log.Println("Failed to relay")
-		w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+		logger.Warn("Failed to relay", "err", err)
+		countRelayRequest(err)
+		writeError(w, err)
 
 		return
 	}
 	resp, err := signedTx.MarshalJSON()
 	if err != nil {
-		log.Println(err)
-		w.Write([]byte("{\"error\":\"" + err.Error() + "\"}"))
+		logger.Error("Error encoding relayed transaction", "txHash", signedTx.Hash(), "err", err)
+		countRelayRequest(err)
+		writeError(w, err)
 		return
 	}
+	countRelayRequest(nil)
+	logger.Info("Relayed request", "txHash", signedTx.Hash(), "nonce", signedTx.Nonce())
 	w.Write(resp)
 }
 
+// txStatusHandler serves GET /tx/{hash-or-nonce}, the status of a transaction sent by the relay
+func txStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.Header()["Access-Control-Allow-Headers"] = []string{"Content-Type, Authorization, Content-Length, X-Requested-With"}
+	w.Header()["Access-Control-Allow-Methods"] = []string{"GET, OPTIONS"}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	query := strings.TrimPrefix(r.URL.Path, "/tx/")
+	var status *librelay.TransactionStatus
+	var err error
+	if strings.HasPrefix(query, "0x") && len(query) == 2+2*common.HashLength {
+		status, err = relay.TransactionStatusByHash(ctx, common.HexToHash(query))
+	} else if nonce, parseErr := strconv.ParseUint(query, 10, 64); parseErr == nil {
+		status, err = relay.TransactionStatusByNonce(ctx, nonce)
+	} else {
+		writeError(w, librelay.NewRelayError(librelay.ErrInvalidRequest, "Expected a transaction hash or nonce but got %s", query))
+		return
+	}
+	if err != nil {
+		log.Error("Failed to get transaction status", "query", query, "err", err)
+		writeError(w, err)
+		return
+	}
+	if status == nil {
+		writeError(w, librelay.NewRelayError(librelay.ErrNotFound, "Transaction %s not found", query))
+		return
+	}
+
+	resp, err := json.Marshal(status)
+	if err != nil {
+		log.Error("Error encoding transaction status", "query", query, "err", err)
+		writeError(w, err)
+		return
+	}
+	w.Write(resp)
+}
+
+// writeError replies with the JSON ErrorResponse of err, and the HTTP status of its RelayError code
+func writeError(w http.ResponseWriter, err error) {
+	relayErr := librelay.AsRelayError(err)
+	resp, marshalErr := json.Marshal(relayErr.Response())
+	if marshalErr != nil {
+		log.Error("Error encoding error response", "err", marshalErr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(relayErr.HTTPStatus())
+	w.Write(resp)
+}
+
+// requestID returns the ID of a relay request, generating it if the client did not set one
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			log.Error("Error generating request ID", "err", err)
+		}
+		id = hex.EncodeToString(random)
+		r.Header.Set(RequestIDHeader, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
 func parseCommandLine() (relayParams librelay.RelayParams) {
 	ownerAddress := flag.String("OwnerAddress", common.HexToAddress("0").Hex(), "Relay's owner address")
 	baseFee := flag.Int64("BaseFee", 0, "Relay's per transaction base fee")
@@ -181,200 +324,352 @@ func parseCommandLine() (relayParams librelay.RelayParams) {
 	relayHubAddress := flag.String("RelayHubAddress", "0xD216153c06E857cD7f72665E0aF1d7D82172F494", "RelayHub address")
 	defaultGasPrice := flag.Int64("DefaultGasPrice", int64(params.GWei), "Relay's default gasPrice per (non-relayed) transaction in wei")
 	gasPricePercent := flag.Int64("GasPricePercent", 10, "Relay's gas price increase as percentage from current average. GasPrice = (100+GasPricePercent)/100 * eth_gasPrice() ")
+	gasPriceOracle := flag.String("GasPriceOracle", librelay.NodeGasPriceOracleType, "Relay's gas price source: node (eth_gasPrice), percentile (of recent blocks' transactions), fixed or http (JSON endpoint)")
+	gasPricePercentileBlocks := flag.Uint64("GasPricePercentileBlocks", 20, "Number of recent blocks sampled by the percentile gas price oracle")
+	gasPricePercentile := flag.Uint64("GasPricePercentile", 60, "Percentile of the sampled gas prices used by the percentile gas price oracle")
+	fixedGasPrice := flag.Int64("FixedGasPrice", int64(params.GWei), "Gas price in wei used by the fixed gas price oracle")
+	gasPriceUrl := flag.String("GasPriceUrl", "", "JSON endpoint queried by the http gas price oracle")
+	gasPriceUrlField := flag.String("GasPriceUrlField", "fast", "Dot separated path of the gas price in the http gas price oracle's JSON response")
+	gasPriceUrlUnit := flag.Int64("GasPriceUrlUnit", int64(params.GWei), "Wei per unit of the gas price returned by the http gas price oracle")
+	minGasPrice := flag.Int64("MinGasPrice", 0, "Minimal gas price in wei suggested by the gas price oracle (0 for no minimum)")
+	maxGasPrice := flag.Int64("MaxGasPrice", 0, "Maximal gas price in wei suggested by the gas price oracle (0 for no maximum)")
+	gasPriceSmoothingPercent := flag.Int64("GasPriceSmoothingPercent", 0, "Weight in percent of each gas price oracle suggestion in a moving average of suggestions (0 for no smoothing)")
+	defaultResendPolicy := librelay.DefaultResendPolicyConfig()
+	resendPolicy := flag.String("ResendPolicy", defaultResendPolicy.Type, "How stuck transactions' fees are bumped: linear (by ResendPercent of the original fee per attempt), exponential (by ResendPercent of the previous fee) or oracle (to ResendPercent over the current gas price)")
+	resendPercent := flag.Int64("ResendPercent", defaultResendPolicy.Percent, "Fee increase percentage applied by the resend policy")
+	resendTimeout := flag.Duration("ResendTimeout", defaultResendPolicy.Timeout, "How long a transaction may stay pending before it is resent")
+	resendMaxGasPrice := flag.Int64("ResendMaxGasPrice", defaultResendPolicy.MaxGasPrice, "Highest gas price in wei of a resent transaction")
+	resendMaxCostPercent := flag.Int64("ResendMaxCostPercent", defaultResendPolicy.MaxTotalCostPercent, "Highest total cost of the transactions resent at once, as percentage of the relay's balance")
 	RegistrationBlockRate:= flag.Uint64("RegistrationBlockRate", REGISTRATION_BLOCK_RATE-200, "Relay registration rate (in blocks, since last sent event)")
 	ethereumNodeUrl := flag.String("EthereumNodeUrl", "http://localhost:8545", "The relay's ethereum node")
 	workdir := flag.String("Workdir", filepath.Join(os.Getenv("PWD"), "data"), "The relay server's workdir")
+	logFormat := flag.String("LogFormat", librelay.LogFormatLogfmt, "Format of the log lines: logfmt, json or terminal")
+	flag.DurationVar(&shutdownTimeout, "ShutdownTimeout", 30*time.Second, "Longest time spent draining in-flight relay requests and stopping background jobs on SIGTERM")
+	flag.DurationVar(&requestTimeout, "RequestTimeout", 30*time.Second, "Longest time spent handling a client request, including the calls it makes to the ethereum node")
+	logLevel := flag.String("LogLevel", "info", "Lowest level of the logged messages: crit, error, warn, info, debug or trace")
+	signerType := flag.String("Signer", librelay.LocalSignerType, "Where the relay's key lives: local (in <Workdir>/keystore) or remote (a JSON-RPC signer such as Clef)")
+	remoteSignerUrl := flag.String("RemoteSignerUrl", "", "JSON-RPC endpoint of the remote signer")
+	remoteSignerAddress := flag.String("RemoteSignerAddress", "", "Account of the remote signer signing the relay's transactions")
+	remoteSignerApi := flag.String("RemoteSignerApi", librelay.ClefSignerAPI, "Remote signer method: account (Clef's account_signTransaction) or eth (eth_signTransaction)")
+	remoteSignerTimeout := flag.Duration("RemoteSignerTimeout", 30*time.Second, "Longest time waiting for the remote signer, which may ask an operator to approve each transaction")
+	flag.StringVar(&mnemonicFile, "MnemonicFile", "", "File holding a BIP-39 mnemonic the relay's key is derived from, instead of the keystore's key, otherwise read from $"+mnemonicEnv)
+	flag.StringVar(&derivationPath, "DerivationPath", accounts.DefaultBaseDerivationPath.String(), "BIP-32 path of the key derived from the mnemonic")
+	flag.StringVar(&keystorePassphraseFile, "KeystorePassphraseFile", "", "File holding the passphrase of the relay's keys, otherwise read from $"+keystorePassphraseEnv+" or prompted for")
+	flag.StringVar(&newKeystorePassphraseFile, "NewKeystorePassphraseFile", "", "File holding the new passphrase of the rotate-keystore-passphrase command, otherwise read from $"+newKeystorePassphraseEnv+" or prompted for")
+	flag.BoolVar(&penalizeRelays, "PenalizeRelays", false, "Watch the registered relays' transactions and penalize repeated nonces and illegal transactions, from the key in <Workdir>/penalizer-keystore")
+	flag.BoolVar(&penalizeScanPending, "PenalizeScanPending", false, "Also watch the pending block, to penalize offenders before their transactions are mined")
 	flag.BoolVar(&devMode, "DevMode", false, "Enable developer mode (do not retry unconfirmed txs, do not cache account nonce, do not wait after calls to the chain, faster polling)")
 
+	flag.StringVar(&configFile, "Config", "", "YAML (.yaml), TOML (.toml) or JSON (.json) file of settings named after these flags, overridden by the GSN_* environment variables (e.g. GSN_RELAY_HUB_ADDRESS), themselves overridden by flags")
+
 	flag.Parse()
 
+	sources, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load configuration:", err)
+		os.Exit(2)
+	}
+	if err := librelay.SetupLogging(os.Stderr, *logFormat, *logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not set up logging:", err)
+		os.Exit(1)
+	}
+
+	for name, address := range map[string]string{"OwnerAddress": *ownerAddress, "RelayHubAddress": *relayHubAddress} {
+		if !common.IsHexAddress(address) {
+			log.Crit("Invalid address", "setting", name, "address", address)
+		}
+	}
 	relayParams.OwnerAddress = common.HexToAddress(*ownerAddress)
 	relayParams.BaseFee = big.NewInt(*baseFee)
 	relayParams.PercentFee = big.NewInt(*percentFee)
 	relayParams.Url = *urlStr
 	u, err := url.Parse(*urlStr)
 	if err != nil {
-		log.Fatalln("Could not parse url")
+		log.Crit("Could not parse url", "url", *urlStr, "err", err)
 	}
 	if *port == "" && u.Port() != "" {
-		log.Println("Using default published port given in url:", *port)
 		*port = u.Port()
+		log.Info("Using default published port given in url", "port", *port)
 	}
 
 	relayParams.Port = *port
 	relayParams.RelayHubAddress = common.HexToAddress(*relayHubAddress)
 	relayParams.DefaultGasPrice = *defaultGasPrice
 	relayParams.GasPricePercent = big.NewInt(*gasPricePercent)
+	relayParams.GasPriceOracleConfig = librelay.GasPriceOracleConfig{
+		Type:             *gasPriceOracle,
+		PercentileBlocks: *gasPricePercentileBlocks,
+		Percentile:       *gasPricePercentile,
+		FixedGasPrice:    *fixedGasPrice,
+		URL:              *gasPriceUrl,
+		URLField:         *gasPriceUrlField,
+		URLUnit:          *gasPriceUrlUnit,
+		URLTimeout:       10 * time.Second,
+		MinGasPrice:      *minGasPrice,
+		MaxGasPrice:      *maxGasPrice,
+		SmoothingPercent: *gasPriceSmoothingPercent,
+	}
+	relayParams.ResendPolicyConfig = librelay.ResendPolicyConfig{
+		Type:                *resendPolicy,
+		Percent:             *resendPercent,
+		Timeout:             *resendTimeout,
+		MaxGasPrice:         *resendMaxGasPrice,
+		MaxTotalCostPercent: *resendMaxCostPercent,
+	}
+	relayParams.SignerConfig = librelay.SignerConfig{
+		Type:          *signerType,
+		RemoteURL:     *remoteSignerUrl,
+		RemoteAddress: common.HexToAddress(*remoteSignerAddress),
+		RemoteAPI:     *remoteSignerApi,
+		RemoteTimeout: *remoteSignerTimeout,
+	}
 	relayParams.RegistrationBlockRate = *RegistrationBlockRate
 	relayParams.EthereumNodeURL = *ethereumNodeUrl
 	relayParams.DBFile = filepath.Join(*workdir, "db")
+	relayParams.SignedNoncesDBFile = filepath.Join(*workdir, "signed-nonces")
 	relayParams.DevMode = devMode
+	relayParams.Settings = configSettings(sources)
 
 	KeystoreDir = filepath.Join(*workdir, "keystore")
+	PenalizerKeystoreDir = filepath.Join(*workdir, "penalizer-keystore")
 
 	// Dumping initial configuration
-	log.Println("Workdir:", *workdir)
+	log.Info("Workdir", "path", *workdir)
 	relayParams.Dump()
+	if err = relayParams.Validate(); err != nil {
+		log.Crit("Invalid configuration", "err", err)
+	}
 
 	return relayParams
 
 }
 
+// configRelay constructs the relay server, exiting if any of its parts cannot be, and recovers the transactions a
+// crash left unsent
 func configRelay(relayParams librelay.RelayParams) {
-	log.Println("Constructing relay server in url ", relayParams.Url)
-	privateKey := loadPrivateKey(KeystoreDir)
-	log.Println("relay server address: ", crypto.PubkeyToAddress(privateKey.PublicKey).Hex())
+	log.Info("Constructing relay server", "url", relayParams.Url)
+	signer, err := newSigner(relayParams.SignerConfig)
+	if err != nil {
+		log.Crit("Could not create signer", "err", err)
+	}
+	log.Info("Relay server address", "address", signer.Address())
 	client, err := librelay.NewEthClient(relayParams.EthereumNodeURL, relayParams.DefaultGasPrice)
 	if err != nil {
-		log.Println("Could not connect to ethereum node", err)
-		return
+		log.Crit("Could not connect to ethereum node", "err", err)
 	}
 	txStore, err := txstore.NewLevelDbTxStore(relayParams.DBFile, nil)
 	if err != nil {
-		log.Println("Could not create local transactions database", err)
-		return
+		log.Crit("Could not create local transactions database", "err", err)
+	}
+	gasPriceOracle, err := librelay.NewGasPriceOracle(relayParams.GasPriceOracleConfig, client)
+	if err != nil {
+		log.Crit("Could not create gas price oracle", "err", err)
 	}
-	relay, err = librelay.NewRelayServer(
+	resendPolicy, err := librelay.NewResendPolicy(relayParams.ResendPolicyConfig)
+	if err != nil {
+		log.Crit("Could not create resend policy", "err", err)
+	}
+	signedNonceStore, err := txstore.NewLevelDbSignedNonceStore(relayParams.SignedNoncesDBFile)
+	if err != nil {
+		log.Crit("Could not create signed nonces database", "err", err)
+	}
+	relayServer, err := librelay.NewRelayServer(
 		relayParams.OwnerAddress, relayParams.BaseFee, relayParams.PercentFee, relayParams.Url, relayParams.Port,
 		relayParams.RelayHubAddress, relayParams.DefaultGasPrice, relayParams.GasPricePercent,
-		privateKey, relayParams.RegistrationBlockRate, relayParams.EthereumNodeURL,
-		client, txStore, nil, relayParams.DevMode)
+		signer, relayParams.RegistrationBlockRate, relayParams.EthereumNodeURL,
+		client, txStore, signedNonceStore, nil, relayParams.DevMode)
 	if err != nil {
-		log.Println("Could not create Relay Server", err)
-		return
+		log.Crit("Could not create Relay Server", "err", err)
+	}
+	relayServer.GasPriceOracle = gasPriceOracle
+	relayServer.ResendPolicy = resendPolicy
+	relay = relayServer
+
+	ctx, cancel := context.WithTimeout(context.Background(), librelay.UnsentRecoveryTimeout)
+	defer cancel()
+	if err = relayServer.RecoverUnsentTransactions(ctx); err != nil {
+		// Left unsent, they are resent as stuck transactions by UpdateUnconfirmedTransactions
+		log.Error("Error recovering unsent transactions", "err", err)
 	}
 }
 
 // Wait for server to be staked & funded by owner, then try and register on RelayHub
-func refreshBlockchainView() {
+func refreshBlockchainView(ctx context.Context) {
 	if removed {
-		log.Println("Relay removed. No need to wait for owner actions")
+		log.Debug("Relay removed. No need to wait for owner actions")
 		return
 	}
-	waitForOwnerActions()
-	_, err := relay.BlockCountSinceLastEvent()
-	for ; err != nil; _, err = relay.BlockCountSinceLastEvent() {
+	if !waitForOwnerActions(ctx) {
+		return
+	}
+	_, err := relay.BlockCountSinceLastEvent(ctx)
+	for ; err != nil; _, err = relay.BlockCountSinceLastEvent(ctx) {
 		if err != nil {
-			log.Println(err)
+			log.Warn("Relay not registered", "err", err)
+			setReadinessCheck(checkRegistered, false, err.Error())
 		}
 		ready = false
-		sleep(15*time.Second, devMode)
+		if !sleep(ctx, 15*time.Second, devMode) {
+			return
+		}
 	}
+	setReadinessCheck(checkRegistered, true, "")
 
-	for err := relay.RefreshGasPrice(); err != nil; err = relay.RefreshGasPrice() {
+	for err := relay.RefreshGasPrice(ctx); err != nil; err = relay.RefreshGasPrice(ctx) {
 		if err != nil {
-			log.Println(err)
+			log.Error("Error refreshing gas price", "err", err)
+			setReadinessCheck(checkGasPrice, false, err.Error())
 		}
 		ready = false
-		sleep(10*time.Second, devMode)
+		if !sleep(ctx, 10*time.Second, devMode) {
+			return
+		}
 
 	}
+	gasPrice := relay.GasPrice()
+	setReadinessCheck(checkGasPrice, true, gasPrice.String())
+
+	if !reconciled {
+		report, err := relay.ReconcileTxStore(ctx)
+		if err != nil {
+			log.Error("Error reconciling TxStore with the chain", "err", err)
+			setReadinessCheck(checkReconciled, false, err.Error())
+			ready = false
+			return
+		}
+		report.Dump(log.Root())
+		setReadinessCheck(checkReconciled, true, "")
+		reconciled = true
+	}
+
 	if !ready {
-		log.Println("Relay ready for client requests.")
+		log.Info("Relay ready for client requests")
 	}
 	ready = true
 }
 
-func updatePendingTxs() {
+func updatePendingTxs(ctx context.Context) {
 	if removed {
-		log.Println("Relay removed. No need to wait for owner actions")
+		log.Debug("Relay removed. No need to wait for owner actions")
+		return
+	}
+	if !waitForOwnerActions(ctx) {
 		return
 	}
-	waitForOwnerActions()
 
-	_, err := relay.UpdateUnconfirmedTransactions()
+	_, err := relay.UpdateUnconfirmedTransactions(ctx)
 	if err != nil {
-		log.Println("Error updating unconfirmed txs", err)
+		log.Error("Error updating unconfirmed txs", "err", err)
 	}
 }
 
-func waitForOwnerActions() {
+// waitForOwnerActions returns once the relay is staked and funded, or false if ctx is done first
+func waitForOwnerActions(ctx context.Context) bool {
 	if removed {
-		log.Println("Relay removed. No need to wait for owner actions")
-		return
+		log.Debug("Relay removed. No need to wait for owner actions")
+		return true
 	}
-	staked, err := relay.IsStaked()
-	for ; err != nil || !staked; staked, err = relay.IsStaked() {
+	staked, err := relay.IsStaked(ctx)
+	for ; err != nil || !staked; staked, err = relay.IsStaked(ctx) {
 		if err != nil {
-			log.Println(err)
+			log.Error("Error checking stake", "err", err)
+			setReadinessCheck(checkStaked, false, err.Error())
+		} else {
+			setReadinessCheck(checkStaked, false, "waiting for stake")
 		}
 		ready = false
-		log.Println("Waiting for stake...")
-		sleep(5*time.Second, devMode)
+		log.Info("Waiting for stake...")
+		if !sleep(ctx, 5*time.Second, devMode) {
+			return false
+		}
 	}
+	setReadinessCheck(checkStaked, true, "")
 
 	// wait for funding
-	balance, err := relay.Balance()
+	balance, err := relay.Balance(ctx)
 	if err != nil {
-		log.Println(err)
-		return
+		log.Error("Error getting relay balance", "err", err)
+		setReadinessCheck(checkFunded, false, err.Error())
+		return true
 	}
-	for ; err != nil || balance.Cmp(minimumRelayBalance) <= 0; balance, err = relay.Balance() {
+	for ; err != nil || balance.Cmp(minimumRelayBalance) <= 0; balance, err = relay.Balance(ctx) {
 		ready = false
-		log.Printf("Server's balance too low (%s, required %s). Waiting for funding...", balance.String(), minimumRelayBalance.String())
-		sleep(10*time.Second, devMode)
+		log.Warn("Server's balance too low. Waiting for funding...", "balance", balance, "required", minimumRelayBalance)
+		setReadinessCheck(checkFunded, false, "balance "+balance.String()+" not above "+minimumRelayBalance.String())
+		if !sleep(ctx, 10*time.Second, devMode) {
+			return false
+		}
 	}
+	setReadinessCheck(checkFunded, true, balance.String())
+	return true
 }
 
-func keepAlive() {
+func keepAlive(ctx context.Context) {
 	if removed {
-		log.Println("Relay removed. No need to reregister")
+		log.Debug("Relay removed. No need to reregister")
 		return
 	}
-	waitForOwnerActions()
-	count, err := relay.BlockCountSinceLastEvent()
+	if !waitForOwnerActions(ctx) {
+		return
+	}
+	count, err := relay.BlockCountSinceLastEvent(ctx)
 	if err != nil {
-		log.Println(err)
+		log.Warn("Relay not registered", "err", err)
 	} else if count < relay.GetRegistrationBlockRate() {
 		return
 	}
-	log.Println("Registering relay...")
+	log.Info("Registering relay...")
 
-	err = relay.RegisterRelay()
+	err = relay.RegisterRelay(ctx)
 	if err == nil {
-		log.Println("Done registering")
+		log.Info("Done registering")
 		return
 	}
-	log.Println(err)
+	log.Error("Error registering relay", "err", err)
 }
 
-func stopServingOnRelayRemoved() {
+func stopServingOnRelayRemoved(ctx context.Context) {
 	var err error
-	removed, err = relay.IsRemoved()
+	removed, err = relay.IsRemoved(ctx)
 	if err != nil {
-		log.Println(err)
+		log.Error("Error checking relay removal", "err", err)
 		return
 	}
+	setReadinessCheck(checkNotRemoved, !removed, "")
 	if removed {
-		log.Println("Relay removed. Listening to Unstaked event")
-		schedule(shutdownOnRelayUnstaked, 1*timeUnit, 0)
+		log.Warn("Relay removed. Listening to Unstaked event")
+		schedule(ctx, shutdownOnRelayUnstaked, 1*timeUnit, 0)
 		stopListeningToRelayRemoved <- true
 	}
 
 }
 
-func shutdownOnRelayUnstaked() {
+func shutdownOnRelayUnstaked(ctx context.Context) {
 	var err error
-	unstaked, err := relay.IsUnstaked()
+	unstaked, err := relay.IsUnstaked(ctx)
 	if err != nil {
-		log.Println(err)
+		log.Error("Error checking relay unstake", "err", err)
 		return
 	}
 	if unstaked {
-		log.Println("Relay unstaked. Sending balance back to owner")
-		sleep(2*time.Minute, devMode)
+		log.Warn("Relay unstaked. Sending balance back to owner")
+		if !sleep(ctx, 2*time.Minute, devMode) {
+			return
+		}
 		for {
-			err = relay.SendBalanceToOwner()
+			err = relay.SendBalanceToOwner(ctx)
 			if err == nil {
 				break
 			}
-			sleep(5*time.Second, devMode)
+			if !sleep(ctx, 5*time.Second, devMode) {
+				return
+			}
 		}
-		server.Close()
+		go shutdown()
 	}
 
 }
 
 func shouldHandleRelayRequests() bool {
-	return ready && !removed
+	return ready && !removed && !shuttingDown()
 }
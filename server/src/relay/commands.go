@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"openeth.dev/librelay"
+)
+
+// command is an operator task run instead of the server when its name follows the flags, e.g.
+// `RelayHttpServer -Workdir data fill-nonce-gaps`
+type command struct {
+	description string
+	run         func(relayParams librelay.RelayParams) error
+}
+
+var commands = map[string]command{
+	"fill-nonce-gaps": {
+		description: "Send a registerRelay() call for each missing nonce stalling the relay's queued transactions",
+		run:         fillNonceGapsCommand,
+	},
+	"derive-addresses": {
+		description: "Print the addresses derived from the mnemonic at -DerivationPath and the following indexes: derive-addresses [count]",
+		run:         deriveAddressesCommand,
+	},
+	"import-mnemonic-key": {
+		description: "Import the key derived from the mnemonic at -DerivationPath into the empty keystore, encrypted with the keystore passphrase",
+		run:         importMnemonicKeyCommand,
+	},
+	"rotate-keystore-passphrase": {
+		description: "Re-encrypt the relay and penalizer keys with the passphrase from -NewKeystorePassphraseFile, $" + newKeystorePassphraseEnv + " or a prompt",
+		run:         rotateKeystorePassphraseCommand,
+	},
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s: [flags] [command]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		printCommands()
+	}
+}
+
+// runCommand runs the command given after the flags, if any, and returns whether it did
+func runCommand(relayParams librelay.RelayParams) bool {
+	if flag.NArg() == 0 {
+		return false
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Commands:\n", name)
+		printCommands()
+		os.Exit(2)
+	}
+	if err := cmd.run(relayParams); err != nil {
+		log.Crit("Command failed", "command", name, "err", err)
+	}
+	return true
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n    \t%s\n", name, commands[name].description)
+	}
+}
+
+func fillNonceGapsCommand(relayParams librelay.RelayParams) (err error) {
+	configRelay(relayParams)
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err = relay.RefreshGasPrice(ctx); err != nil {
+		return
+	}
+	gaps, fillers, err := relay.FillNonceGaps(ctx)
+	if len(gaps) == 0 && err == nil {
+		log.Info("No nonce gaps found")
+		return
+	}
+	for i, hash := range fillers {
+		log.Info("Nonce gap filled", "nonce", gaps[i], "txHash", hash)
+	}
+	return
+}
+
+// rotateKeystorePassphraseCommand re-encrypts the keys in place with the new passphrase and standard scrypt parameters.
+// Keys created unencrypted are encrypted when no current passphrase is given.
+func rotateKeystorePassphraseCommand(relayParams librelay.RelayParams) error {
+	passphrase, err := readPassphrase(keystorePassphraseFile, keystorePassphraseEnv, "Current keystore passphrase", false)
+	if err == errNoPassphrase {
+		passphrase, err = "", nil
+	}
+	if err != nil {
+		return err
+	}
+	newPassphrase, err := readPassphrase(newKeystorePassphraseFile, newKeystorePassphraseEnv, "New keystore passphrase", true)
+	if err != nil {
+		return err
+	}
+	if newPassphrase == "" && !devMode {
+		return fmt.Errorf("empty new keystore passphrase")
+	}
+	for _, keystoreDir := range []string{KeystoreDir, PenalizerKeystoreDir} {
+		if IsEmpty(keystoreDir) {
+			continue
+		}
+		ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+		for _, account := range ks.Accounts() {
+			if err = ks.Update(account, passphrase, newPassphrase); err != nil {
+				return fmt.Errorf("could not re-encrypt %s: %v", filepath.Base(account.URL.Path), err)
+			}
+			log.Info("Key re-encrypted", "address", account.Address, "keystore", keystoreDir)
+		}
+	}
+	log.Info("Keystore passphrase rotated, update the passphrase source before restarting the relay")
+	return nil
+}
+
+func deriveAddressesCommand(relayParams librelay.RelayParams) error {
+	count := 5
+	if flag.NArg() > 1 {
+		var err error
+		if count, err = strconv.Atoi(flag.Arg(1)); err != nil || count <= 0 {
+			return fmt.Errorf("invalid count %q", flag.Arg(1))
+		}
+	}
+	next, err := mnemonicKeys()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		path, key, err := next()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", path, crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+	return nil
+}
+
+// importMnemonicKeyCommand stores the derived key in the keystore format, so the relay runs without the mnemonic
+func importMnemonicKeyCommand(relayParams librelay.RelayParams) error {
+	if !IsEmpty(KeystoreDir) {
+		return fmt.Errorf("keystore %s is not empty, the relay uses its first key", KeystoreDir)
+	}
+	next, err := mnemonicKeys()
+	if err != nil {
+		return err
+	}
+	path, key, err := next()
+	if err != nil {
+		return err
+	}
+	ks := keystore.NewKeyStore(KeystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+	account, err := ks.ImportECDSA(key, keystorePassphrase(true))
+	if err != nil {
+		return err
+	}
+	log.Info("Key imported", "path", path, "address", account.Address, "keystore", KeystoreDir)
+	return nil
+}
+
+// mnemonicKeys returns the keys derived from the mnemonic, which must be given
+func mnemonicKeys() (next func() (accounts.DerivationPath, *ecdsa.PrivateKey, error), err error) {
+	mnemonic, err := readMnemonic()
+	if err != nil {
+		return
+	}
+	if mnemonic == "" {
+		return nil, fmt.Errorf("no mnemonic given in -MnemonicFile or $%s", mnemonicEnv)
+	}
+	return derivedKeys(mnemonic)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"openeth.dev/librelay"
+)
+
+// configFile is a YAML, TOML or JSON file of settings named after the flags, e.g. `RelayHubAddress: 0x...`
+var configFile string
+
+// loadConfig sets the flags not given on the command line from their GSN_* environment variable, or else from the
+// config file, and returns the librelay.ConfigSource* of every flag's value. The precedence is thus: flags, then
+// environment, then config file, then defaults.
+func loadConfig() (sources map[string]string, err error) {
+	sources = make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = librelay.ConfigSourceDefault
+	})
+	flag.Visit(func(f *flag.Flag) {
+		sources[f.Name] = librelay.ConfigSourceFlag
+	})
+
+	// The config file itself can only be named by a flag or the environment
+	if value, ok := os.LookupEnv(librelay.ConfigEnvName("Config")); ok && sources["Config"] != librelay.ConfigSourceFlag {
+		configFile = value
+		sources["Config"] = librelay.ConfigSourceEnv
+	}
+	if configFile != "" {
+		values, err := librelay.ReadConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range values {
+			if flag.Lookup(name) == nil || name == "Config" {
+				return nil, fmt.Errorf("%s: unknown setting %s", configFile, name)
+			}
+			if sources[name] != librelay.ConfigSourceDefault {
+				continue
+			}
+			if err = flag.Set(name, value); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s: %v", configFile, name, err)
+			}
+			sources[name] = librelay.ConfigSourceFile
+		}
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(librelay.ConfigEnvName(f.Name))
+		if !ok || err != nil || f.Name == "Config" || sources[f.Name] == librelay.ConfigSourceFlag {
+			return
+		}
+		if err = f.Value.Set(value); err != nil {
+			err = fmt.Errorf("invalid %s: %v", librelay.ConfigEnvName(f.Name), err)
+			return
+		}
+		sources[f.Name] = librelay.ConfigSourceEnv
+	})
+	return
+}
+
+// configSettings returns the value of every flag, along with its source as returned by loadConfig
+func configSettings(sources map[string]string) map[string]librelay.ConfigSetting {
+	settings := make(map[string]librelay.ConfigSetting)
+	flag.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = librelay.ConfigSetting{Value: f.Value.String(), Source: sources[f.Name]}
+	})
+	return settings
+}
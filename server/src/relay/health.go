@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Preconditions to serve relay requests, checked by waitForOwnerActions, refreshBlockchainView and
+// stopServingOnRelayRemoved
+const (
+	checkStaked     = "staked"
+	checkFunded     = "funded"     // balance above minimumRelayBalance
+	checkRegistered = "registered" // RelayAdded within RegistrationBlockRate blocks
+	checkGasPrice   = "gasPrice"
+	checkNotRemoved = "notRemoved"
+	checkReconciled = "reconciled" // TxStore reconciled with the chain once since startup
+)
+
+type ReadinessCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Ready  bool                      `json:"ready"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+// readinessChecks holds the outcome of the latest check of each precondition
+var readinessChecks = struct {
+	sync.Mutex
+	checks map[string]ReadinessCheck
+}{checks: map[string]ReadinessCheck{
+	checkStaked:     {Detail: "not checked yet"},
+	checkFunded:     {Detail: "not checked yet"},
+	checkRegistered: {Detail: "not checked yet"},
+	checkGasPrice:   {Detail: "not checked yet"},
+	checkNotRemoved: {Detail: "not checked yet"},
+	checkReconciled: {Detail: "not checked yet"},
+}}
+
+func setReadinessCheck(name string, ok bool, detail string) {
+	readinessChecks.Lock()
+	defer readinessChecks.Unlock()
+	readinessChecks.checks[name] = ReadinessCheck{OK: ok, Detail: detail}
+}
+
+func readiness() *ReadinessResponse {
+	readinessChecks.Lock()
+	defer readinessChecks.Unlock()
+	response := &ReadinessResponse{Ready: shouldHandleRelayRequests(), Checks: map[string]ReadinessCheck{}}
+	for name, check := range readinessChecks.checks {
+		response.Checks[name] = check
+		response.Ready = response.Ready && check.OK
+	}
+	return response
+}
+
+// healthzHandler serves GET /healthz: whether the process is alive, the ethereum node reachable and the database open
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	health := relay.Health(r.Context())
+	status := http.StatusOK
+	if !health.Healthy {
+		log.Warn("Relay unhealthy", "ethereumNode", health.EthereumNode, "database", health.Database)
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// readyzHandler serves GET /readyz: whether the relay can serve relay requests, with each precondition's status
+func readyzHandler(w http.ResponseWriter, _ *http.Request) {
+	response := readiness()
+	status := http.StatusOK
+	if !response.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	resp, err := json.Marshal(value)
+	if err != nil {
+		log.Error("Error encoding response", "err", err)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
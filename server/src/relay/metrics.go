@@ -0,0 +1,42 @@
+package main
+
+import (
+	"openeth.dev/librelay"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var relayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gsn_relay",
+	Name:      "requests_total",
+	Help:      "Relay requests handled, by outcome (relayed or failed) and error code",
+}, []string{"outcome", "code"})
+
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: "gsn_relay",
+	Name:      "ready",
+	Help:      "Whether the relay is staked, funded, registered and knows the gas price",
+}, func() float64 { return boolToFloat(ready) })
+
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: "gsn_relay",
+	Name:      "removed",
+	Help:      "Whether the relay was removed from RelayHub",
+}, func() float64 { return boolToFloat(removed) })
+
+// countRelayRequest records the outcome of a relay request, err being nil if it was relayed
+func countRelayRequest(err error) {
+	if err == nil {
+		relayRequests.WithLabelValues("relayed", "").Inc()
+		return
+	}
+	relayRequests.WithLabelValues("failed", string(librelay.AsRelayError(err).Code)).Inc()
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
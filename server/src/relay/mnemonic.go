@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"openeth.dev/librelay"
+)
+
+// mnemonicEnv holds the BIP-39 mnemonic of the relay's key, read when no mnemonic file is given
+const mnemonicEnv = "GSN_MNEMONIC"
+
+var mnemonicFile string
+var derivationPath string
+
+// readMnemonic returns the mnemonic from -MnemonicFile or $GSN_MNEMONIC, or "" if neither is given
+func readMnemonic() (string, error) {
+	if mnemonicFile == "" {
+		return strings.TrimSpace(os.Getenv(mnemonicEnv)), nil
+	}
+	content, err := ioutil.ReadFile(mnemonicFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(string(content)), " "), nil
+}
+
+// derivedKeys returns a function deriving the keys at -DerivationPath, then at the next indexes of its last component
+func derivedKeys(mnemonic string) (next func() (accounts.DerivationPath, *ecdsa.PrivateKey, error), err error) {
+	base, err := accounts.ParseDerivationPath(derivationPath)
+	if err != nil {
+		return
+	}
+	nextPath := accounts.DefaultIterator(base)
+	return func() (accounts.DerivationPath, *ecdsa.PrivateKey, error) {
+		path := nextPath()
+		key, err := librelay.DeriveKey(mnemonic, "", path)
+		return path, key, err
+	}, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"openeth.dev/librelay"
+)
+
+func TestNewSignerMnemonicReadError(t *testing.T) {
+	KeystoreDir = t.TempDir()
+	mnemonicFile = filepath.Join(t.TempDir(), "missing-mnemonic")
+	defer func() { KeystoreDir, mnemonicFile = "", "" }()
+
+	if _, err := newSigner(librelay.SignerConfig{Type: librelay.LocalSignerType}); err == nil {
+		t.Fatalf("Expected an error reading the mnemonic")
+	}
+	files, err := ioutil.ReadDir(KeystoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected the keystore to be left empty, found %d files", len(files))
+	}
+}
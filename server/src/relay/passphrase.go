@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"golang.org/x/term"
+)
+
+// Environment variables holding the keystore passphrase, read when no passphrase file is given
+const (
+	keystorePassphraseEnv    = "GSN_KEYSTORE_PASSPHRASE"
+	newKeystorePassphraseEnv = "GSN_NEW_KEYSTORE_PASSPHRASE" // for rotate-keystore-passphrase
+)
+
+var keystorePassphraseFile string
+var newKeystorePassphraseFile string
+
+// keystorePassphraseRead caches the passphrase, which decrypts both the relay and penalizer keys
+var keystorePassphraseRead *string
+
+var errNoPassphrase = errors.New("no passphrase given")
+
+// readPassphrase reads a passphrase from file, or else from the env variable, or else prompts for it when stdin is a
+// terminal, twice if confirm is set. It returns errNoPassphrase if there is no source.
+func readPassphrase(file string, env string, prompt string, confirm bool) (string, error) {
+	if file != "" {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		passphrase := strings.TrimRight(string(content), "\r\n")
+		if passphrase == "" {
+			return "", fmt.Errorf("passphrase file %s is empty", file)
+		}
+		return passphrase, nil
+	}
+	if passphrase, ok := os.LookupEnv(env); ok {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errNoPassphrase
+	}
+	passphrase, err := promptPassphrase(prompt)
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+	repeated, err := promptPassphrase("Repeat " + strings.ToLower(prompt[:1]) + prompt[1:])
+	if err != nil {
+		return "", err
+	}
+	if repeated != passphrase {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt+": ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(passphrase), err
+}
+
+// keystorePassphrase returns the passphrase of the relay's keys. Only DevMode accepts an empty one, which leaves the
+// keys unencrypted.
+func keystorePassphrase(creating bool) string {
+	if keystorePassphraseRead != nil {
+		return *keystorePassphraseRead
+	}
+	passphrase, err := readPassphrase(keystorePassphraseFile, keystorePassphraseEnv, "Keystore passphrase", creating)
+	if err == errNoPassphrase && devMode {
+		log.Warn("No keystore passphrase given, keys are not encrypted in DevMode")
+		err = nil
+	}
+	if err != nil {
+		log.Crit("Could not read keystore passphrase", "err", err,
+			"sources", "-KeystorePassphraseFile, $"+keystorePassphraseEnv+" or a terminal prompt")
+	}
+	if passphrase == "" && !devMode {
+		log.Crit("Empty keystore passphrase, keys would not be encrypted")
+	}
+	keystorePassphraseRead = &passphrase
+	return passphrase
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"openeth.dev/librelay"
+)
+
+// PenalizerKeystoreDir holds the key sending penalizations, which must not be the relay's
+var PenalizerKeystoreDir string
+
+var penalizeRelays bool
+var penalizeScanPending bool
+var penalizationWatcher *librelay.PenalizationWatcher
+var stopWatchingOffenders chan bool
+
+type ReportRequest struct {
+	SignedTx hexutil.Bytes `json:"signedTx"` // a transaction returned by a relay, RLP encoded
+}
+
+type ReportResponse struct {
+	Offense *librelay.Offense `json:"offense"`
+}
+
+func configPenalizationWatcher(relayParams librelay.RelayParams) {
+	reporterKey := loadPrivateKey(PenalizerKeystoreDir)
+	client, err := librelay.NewEthClient(relayParams.EthereumNodeURL, relayParams.DefaultGasPrice)
+	if err != nil {
+		log.Crit("Could not connect to ethereum node", "err", err)
+	}
+	penalizationWatcher, err = librelay.NewPenalizationWatcher(client, relayParams.RelayHubAddress, reporterKey, relay.Address())
+	if err != nil {
+		log.Crit("Could not create penalization watcher", "err", err)
+	}
+	penalizationWatcher.ScanPending = penalizeScanPending
+	log.Info("Penalizing offending relays", "reporter", crypto.PubkeyToAddress(reporterKey.PublicKey), "scanPending", penalizeScanPending)
+}
+
+func watchOffenders(ctx context.Context) {
+	offenses, err := penalizationWatcher.Scan(ctx)
+	if err != nil {
+		log.Error("Error scanning for relay offenses", "err", err)
+	}
+	for _, offense := range offenses {
+		log.Warn("Relay offense", "relay", offense.Relay, "offense", offense.Kind, "nonce", offense.Nonce, "penalizationTxHash", offense.PenalizationTxHash)
+	}
+}
+
+// reportHandler serves POST /report: a client reports a transaction a relay returned, so it is compared with the
+// relay's other transactions, and penalized if it repeats a nonce or is illegal
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header()["Access-Control-Allow-Origin"] = []string{"*"}
+	w.Header()["Access-Control-Allow-Headers"] = []string{"Content-Type, Authorization, Content-Length, X-Requested-With"}
+	w.Header()["Access-Control-Allow-Methods"] = []string{"POST, OPTIONS"}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var request ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, librelay.NewRelayError(librelay.ErrInvalidRequest, "Invalid JSON request: %v", err))
+		return
+	}
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(request.SignedTx); err != nil {
+		writeError(w, librelay.NewRelayError(librelay.ErrInvalidRequest, "Invalid signed transaction: %v", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+	defer cancel()
+	offense, err := penalizationWatcher.Observe(ctx, &tx)
+	if err != nil {
+		log.Error("Error checking reported transaction", "txHash", tx.Hash(), "err", err)
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &ReportResponse{Offense: offense})
+}
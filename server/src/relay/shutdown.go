@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// relayCloseTimeout bounds the time spent closing the relay, which flushes the TxStore
+const relayCloseTimeout = 10 * time.Second
+
+// shutdownTimeout bounds the time spent draining in-flight relay requests and stopping the background jobs
+var shutdownTimeout time.Duration
+
+var shutdownOnce sync.Once
+
+// shutdownComplete is closed once the relay is closed, and the process may exit
+var shutdownComplete = make(chan struct{})
+
+// runningJobs counts the goroutines started by schedule
+var runningJobs sync.WaitGroup
+
+// relayDrain counts the relay requests in flight, which shutdown waits for
+var relayDrain = struct {
+	sync.Mutex
+	closing  bool
+	inFlight int
+	drained  chan struct{}
+}{drained: make(chan struct{})}
+
+// beginRelay registers a relay request in flight, unless the server is shutting down
+func beginRelay() bool {
+	relayDrain.Lock()
+	defer relayDrain.Unlock()
+	if relayDrain.closing {
+		return false
+	}
+	relayDrain.inFlight++
+	return true
+}
+
+func endRelay() {
+	relayDrain.Lock()
+	defer relayDrain.Unlock()
+	relayDrain.inFlight--
+	if relayDrain.closing && relayDrain.inFlight == 0 {
+		close(relayDrain.drained)
+	}
+}
+
+func shuttingDown() bool {
+	relayDrain.Lock()
+	defer relayDrain.Unlock()
+	return relayDrain.closing
+}
+
+// drainRelays stops accepting relay requests, and waits for those in flight until deadline. Returns false on timeout.
+func drainRelays(deadline time.Time) bool {
+	relayDrain.Lock()
+	relayDrain.closing = true
+	if relayDrain.inFlight == 0 {
+		close(relayDrain.drained)
+	}
+	relayDrain.Unlock()
+
+	select {
+	case <-relayDrain.drained:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// handleShutdownSignals shuts the server down on SIGINT or SIGTERM
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Info("Received signal, shutting down", "signal", sig)
+		shutdown()
+	}()
+}
+
+// shutdown waits for in-flight relay requests, stops the background jobs and the HTTP server, and closes the relay.
+// Background jobs must call it in a new goroutine, as it waits for them to return.
+func shutdown() {
+	shutdownOnce.Do(func() {
+		defer close(shutdownComplete)
+		deadline := time.Now().Add(shutdownTimeout)
+
+		if drainRelays(deadline) {
+			log.Info("Drained in-flight relay requests")
+		} else {
+			relayDrain.Lock()
+			log.Warn("Timed out waiting for in-flight relay requests", "inFlight", relayDrain.inFlight)
+			relayDrain.Unlock()
+		}
+
+		for _, stop := range []chan bool{stopKeepAlive, stopRefreshBlockchainView, stopUpdatingPendingTxs, stopListeningToRelayRemoved, stopWatchingOffenders} {
+			select {
+			case stop <- true:
+			default:
+			}
+		}
+		// Jobs waiting for owner actions or polling the node only notice cancellation
+		cancelJobs()
+		if waitTimeout(&runningJobs, time.Until(deadline)) {
+			log.Info("Stopped background jobs")
+		} else {
+			log.Warn("Timed out waiting for background jobs to stop")
+		}
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Warn("Error shutting down HTTP server", "err", err)
+		}
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- relay.Close()
+		}()
+		select {
+		case err := <-closed:
+			if err != nil {
+				log.Error("Error closing relay", "err", err)
+				return
+			}
+			log.Info("Relay closed")
+		case <-time.After(relayCloseTimeout):
+			log.Error("Timed out closing relay")
+		}
+	})
+}
+
+// waitTimeout waits for wg, and returns false if timeout elapses first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
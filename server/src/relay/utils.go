@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"fmt"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/log"
 	"io"
 	"io/ioutil"
-	"log"
+	"openeth.dev/librelay"
 	"os"
 	"path/filepath"
 	"time"
@@ -26,26 +29,24 @@ func IsEmpty(name string) bool {
 	return false // Either not empty or error, suits both cases
 }
 
-// Loads (creates if doesn't exist) private key from keystore file
+// Loads (creates if doesn't exist) private key from keystore file, encrypted with keystorePassphrase(). Outside DevMode,
+// an unencrypted key is refused: rotate-keystore-passphrase encrypts it.
 func loadPrivateKey(keystoreDir string) *ecdsa.PrivateKey {
 	// Init a keystore
 	ks := keystore.NewKeyStore(
 		keystoreDir,
-		keystore.LightScryptN,
-		keystore.LightScryptP)
+		keystore.StandardScryptN,
+		keystore.StandardScryptP)
 
 	// find (or create) account
 	var account accounts.Account
 	var err error
 	if _, err = os.Stat(filepath.Join(keystoreDir, "")); os.IsNotExist(err) || IsEmpty(keystoreDir) {
-		account, err = ks.NewAccount("")
+		account, err = ks.NewAccount(keystorePassphrase(true))
 		if err != nil {
-			log.Fatal(err)
-		}
-		// Unlock the signing account
-		if err := ks.Unlock(account, ""); err != nil {
-			log.Fatalln(err)
+			log.Crit("Could not create account", "err", err)
 		}
+		log.Info("Created key", "address", account.Address, "keystore", keystoreDir)
 	} else {
 		account = ks.Accounts()[0]
 	}
@@ -54,31 +55,77 @@ func loadPrivateKey(keystoreDir string) *ecdsa.PrivateKey {
 
 	keyJson, err := ioutil.ReadFile(account.URL.Path)
 	if err != nil {
-		log.Fatalln("key json read error:")
-		panic(err)
+		log.Crit("key json read error", "err", err)
 	}
 
-	keyWrapper, err := keystore.DecryptKey(keyJson, "")
+	passphrase := keystorePassphrase(false)
+	keyWrapper, err := keystore.DecryptKey(keyJson, passphrase)
+	if err != nil && passphrase != "" {
+		if unencrypted, plainErr := keystore.DecryptKey(keyJson, ""); plainErr == nil {
+			if !devMode {
+				log.Crit("Key is not encrypted, run the rotate-keystore-passphrase command", "address", unencrypted.Address, "keystore", keystoreDir)
+			}
+			log.Warn("Key is not encrypted", "address", unencrypted.Address, "keystore", keystoreDir)
+			keyWrapper, err = unencrypted, nil
+		}
+	}
 	if err != nil {
-		log.Fatalln("key decrypt error:")
+		log.Crit("key decrypt error", "err", err)
 	}
-	log.Println("key extracted. addr:", keyWrapper.Address.String())
+	log.Info("key extracted", "address", keyWrapper.Address)
 
 	return keyWrapper.PrivateKey
 }
 
-func schedule(job func(), delay time.Duration, when time.Duration) chan bool {
+// newSigner returns the signer described by config. The local signer's key is derived from the mnemonic if one is
+// given, otherwise loaded from KeystoreDir.
+func newSigner(config librelay.SignerConfig) (librelay.Signer, error) {
+	switch config.Type {
+	case "", librelay.LocalSignerType:
+		// An unreadable mnemonic must not fall back to the keystore, which would create a key nobody asked for
+		mnemonic, err := readMnemonic()
+		if err != nil {
+			return nil, err
+		}
+		if mnemonic == "" {
+			return librelay.NewLocalSigner(loadPrivateKey(KeystoreDir)), nil
+		}
+		next, err := derivedKeys(mnemonic)
+		if err != nil {
+			return nil, err
+		}
+		path, key, err := next()
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Relay key derived from mnemonic", "path", path)
+		return librelay.NewLocalSigner(key), nil
+	case librelay.RemoteSignerType:
+		return librelay.NewRemoteSigner(config)
+	default:
+		return nil, fmt.Errorf("unknown signer %q", config.Type)
+	}
+}
 
-	stop := make(chan bool)
+// schedule runs job after when, then every delay, until stop is sent or ctx is done. Sending stop never blocks.
+func schedule(ctx context.Context, job func(context.Context), delay time.Duration, when time.Duration) chan bool {
 
+	stop := make(chan bool, 1)
+
+	runningJobs.Add(1)
 	go func() {
-		time.Sleep(when)
+		defer runningJobs.Done()
+		if !sleep(ctx, when, false) {
+			return
+		}
 		for {
-			job()
+			job(ctx)
 			select {
 			case <-time.After(delay):
 			case <-stop:
 				return
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
@@ -86,10 +133,15 @@ func schedule(job func(), delay time.Duration, when time.Duration) chan bool {
 	return stop
 }
 
-func sleep(duration time.Duration, shortSleep bool) {
+// sleep waits for duration, or a second on shortSleep, and returns false if ctx is done first
+func sleep(ctx context.Context, duration time.Duration, shortSleep bool) bool {
 	if shortSleep {
-		time.Sleep(time.Second)
-	} else {
-		time.Sleep(duration)
+		duration = time.Second
+	}
+	select {
+	case <-time.After(duration):
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
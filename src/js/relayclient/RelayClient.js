@@ -192,6 +192,12 @@ class RelayClient {
               url: relayUrl
             }
           }
+          if (error.code) {
+            // Relay errors are sent with a non-200 status and a machine readable code
+            console.log('Got error response from relay', error.code, error.error)
+            reject(error.error)
+            return
+          }
           reject(error)
           return
         }